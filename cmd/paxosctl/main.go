@@ -0,0 +1,293 @@
+//paxosctl : client of a paxos cluster, to submit values and read the log.
+//
+//	paxosctl [-config cluster.cfg] [-id 9 -data dir] [-listen host:port] [-timeout 5s] [-v] command [args]
+//
+//	submit <key> <value>  put key=value through the cluster
+//	get <iid>             entry of the log at iid
+//	tail [-n 10] [-f]     last entries of the log, -f: follow it
+//	leader                leader proposer, as a learner knows it
+//	add <role> <id>       server id takes role: proposer, acceptor or learner
+//	remove <role> <id>    server id leaves role
+//
+//The cluster file of the servers will do, the client takes the server lists
+//and addresses from it. It runs as a new ephemeral client each time,
+//unless given an -id; a fixed ID needs its state kept with -data, else
+//the call seq starts over and the cluster takes new calls for retries
+//of old ones.
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/wilem/simple-paxos/config"
+	"github.com/wilem/simple-paxos/paxos"
+)
+
+//ErrTimeout : no reply from the cluster in time.
+var ErrTimeout = errors.New("no reply in time")
+
+//ctl : client node, its calls made synchronous.
+type ctl struct {
+	node    *paxos.Node
+	timeout time.Duration
+}
+
+func main() {
+	cfgFile := flag.String("config", "cluster.cfg", "ClusterConfig file of the cluster")
+	id := flag.Uint("id", 0, "client node ID, 0: a new ephemeral one")
+	dataDir := flag.String("data", "", "directory of the client state, for a fixed -id")
+	listen := flag.String("listen", "", "host:port to listen on, default: own entry of Addrs, else any port")
+	timeout := flag.Duration("timeout", 5*time.Second, "wait for a reply this long")
+	verbose := flag.Bool("v", false, "log the protocol msgs of the client node")
+	flag.Usage = usage
+	flag.Parse()
+	if flag.NArg() == 0 {
+		usage()
+		os.Exit(2)
+	}
+	if !*verbose {
+		log.SetOutput(io.Discard)
+	}
+
+	nodeID := uint32(*id)
+	if nodeID == 0 {
+		nodeID = paxos.EphemeralClientID()
+	}
+	cfg, err := config.LoadClusterConfig(*cfgFile, nodeID)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "paxosctl: load config %s: %s\n", *cfgFile, err)
+		os.Exit(1)
+	}
+	cfg.DataDir = *dataDir //not the one of the server the config may be for.
+	cfg.Listen = *listen
+	if _, ok := cfg.Addrs[cfg.NodeID]; !ok && cfg.Listen == "" {
+		cfg.Listen = ":0" //any port, servers reply where we send from.
+	}
+	node, err := paxos.NewNodeCluster(cfg)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "paxosctl: config %s: %s\n", *cfgFile, err)
+		os.Exit(1)
+	}
+	c := &ctl{node: node, timeout: *timeout}
+	if err := c.node.Start(); err != nil {
+		fmt.Fprintf(os.Stderr, "paxosctl: start node %d: %s\n", cfg.NodeID, err)
+		os.Exit(1)
+	}
+	err = c.run(flag.Arg(0), flag.Args()[1:])
+	if serr := c.node.Stop(); serr != nil && err == nil {
+		err = serr
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "paxosctl:", err)
+		os.Exit(1)
+	}
+}
+
+func usage() {
+	fmt.Fprintf(flag.CommandLine.Output(), `usage: paxosctl [flags] command [args]
+
+commands:
+  submit <key> <value>  put key=value through the cluster
+  get <iid>             entry of the log at iid
+  tail [-n 10] [-f]     last entries of the log, -f: follow it
+  leader                leader proposer, as a learner knows it
+  add <role> <id>       server id takes role: proposer, acceptor or learner
+  remove <role> <id>    server id leaves role
+
+flags:
+`)
+	flag.PrintDefaults()
+}
+
+//run : command cmd with args.
+func (c *ctl) run(cmd string, args []string) error {
+	switch cmd {
+	case "submit":
+		if len(args) != 2 {
+			return errors.New("usage: submit <key> <value>")
+		}
+		if _, err := c.do(&paxos.KVOp{Op: paxos.KVOpPut, Key: args[0], Val: args[1]}); err != nil {
+			return err
+		}
+		fmt.Println("ok")
+	case "get":
+		if len(args) != 1 {
+			return errors.New("usage: get <iid>")
+		}
+		iid, err := strconv.ParseUint(args[0], 10, 32)
+		if err != nil || iid == 0 {
+			return fmt.Errorf("bad iid: %q", args[0])
+		}
+		ent, err := c.query(uint32(iid))
+		if err != nil {
+			return err
+		}
+		fmt.Println(formatEntry(ent))
+	case "tail":
+		fs := flag.NewFlagSet("tail", flag.ContinueOnError)
+		n := fs.Uint("n", 10, "number of entries")
+		follow := fs.Bool("f", false, "print new entries as they are chosen")
+		if err := fs.Parse(args); err != nil {
+			return err
+		}
+		return c.tail(uint32(*n), *follow)
+	case "leader":
+		ent, err := c.query(0)
+		if err != nil {
+			return err
+		}
+		if ent.Leader == 0 {
+			fmt.Printf("unknown (learner %d)\n", ent.From)
+			break
+		}
+		fmt.Printf("%d (learner %d)\n", ent.Leader, ent.From)
+	case "add", "remove":
+		if len(args) != 2 {
+			return fmt.Errorf("usage: %s <role> <id>", cmd)
+		}
+		op := &paxos.MemberOp{Op: paxos.MemberOpAdd}
+		if cmd == "remove" {
+			op.Op = paxos.MemberOpRemove
+		}
+		role, err := paxos.ParseRole(args[0])
+		if err != nil {
+			return err
+		}
+		id, err := strconv.ParseUint(args[1], 10, 32)
+		if err != nil || id == 0 {
+			return fmt.Errorf("bad node id: %q", args[1])
+		}
+		op.Role, op.ID = role, uint32(id)
+		res, err := c.reconfigure(op)
+		if err != nil {
+			return err
+		}
+		fmt.Println("ok,", res)
+	default:
+		return fmt.Errorf("unknown command: %q", cmd)
+	}
+	return nil
+}
+
+//tail : last n entries applied, then the new ones if follow.
+func (c *ctl) tail(n uint32, follow bool) error {
+	ent, err := c.query(0)
+	if err != nil {
+		return err
+	}
+	next := uint32(1)
+	if ent.Next > n {
+		next = ent.Next - n
+	}
+	for {
+		for ; next < ent.Next; next++ {
+			e, err := c.query(next)
+			if err != nil {
+				return err
+			}
+			fmt.Println(formatEntry(e))
+		}
+		if !follow {
+			return nil
+		}
+		time.Sleep(500 * time.Millisecond)
+		if ent, err = c.query(0); err != nil {
+			return err
+		}
+	}
+}
+
+//do : KV op through the cluster, its result.
+func (c *ctl) do(op *paxos.KVOp) (string, error) {
+	type result struct {
+		ret int
+		res string
+	}
+	ch := make(chan result, 1)
+	if err := c.node.Do(op, func(ret int, res string) { ch <- result{ret, res} }); err != nil {
+		return "", err
+	}
+	select {
+	case r := <-ch:
+		if r.ret != int(paxos.PxsStatusOK) {
+			return "", fmt.Errorf("call failed, status:%d", r.ret)
+		}
+		return r.res, nil
+	case <-time.After(c.timeout):
+		return "", ErrTimeout
+	}
+}
+
+//reconfigure : membership change op through the cluster, the iid it
+//holds from.
+func (c *ctl) reconfigure(op *paxos.MemberOp) (string, error) {
+	type result struct {
+		ret int
+		res string
+	}
+	ch := make(chan result, 1)
+	if err := c.node.Reconfigure(op, func(ret int, res string) { ch <- result{ret, res} }); err != nil {
+		return "", err
+	}
+	select {
+	case r := <-ch:
+		if r.ret != int(paxos.PxsStatusOK) {
+			return "", fmt.Errorf("change rejected, status:%d: %s", r.ret, r.res)
+		}
+		return r.res, nil
+	case <-time.After(c.timeout):
+		return "", ErrTimeout
+	}
+}
+
+//query : entry of the log at iid, iid 0 for the learner state only.
+func (c *ctl) query(iid uint32) (*paxos.LogEntry, error) {
+	type result struct {
+		ret int
+		ent *paxos.LogEntry
+	}
+	ch := make(chan result, 1)
+	if err := c.node.Query(iid, func(ret int, ent *paxos.LogEntry) { ch <- result{ret, ent} }); err != nil {
+		return nil, err
+	}
+	select {
+	case r := <-ch:
+		if r.ret != int(paxos.PxsStatusOK) && r.ret != int(paxos.PxsStatusNotChosen) {
+			return nil, fmt.Errorf("query failed, status:%d", r.ret)
+		}
+		return r.ent, nil
+	case <-time.After(c.timeout):
+		return nil, ErrTimeout
+	}
+}
+
+//formatEntry : one line per entry, KV and member ops decoded.
+func formatEntry(ent *paxos.LogEntry) string {
+	if ent.Val == nil {
+		return fmt.Sprintf("%d\tnot chosen (applied up to %d)", ent.IID, ent.Next-1)
+	}
+	if mop, err := paxos.DecodeMemberOp(ent.Val); err == nil {
+		verb := "add"
+		if mop.Op == paxos.MemberOpRemove {
+			verb = "remove"
+		}
+		return fmt.Sprintf("%d\t%s %s %d\tcli:%d seq:%d", ent.IID, verb, mop.Role, mop.ID, mop.Cli, mop.Seq)
+	}
+	op, err := paxos.DecodeKVOp(ent.Val)
+	if err != nil {
+		return fmt.Sprintf("%d\t%q", ent.IID, ent.Val.Oct)
+	}
+	switch op.Op {
+	case paxos.KVOpPut:
+		return fmt.Sprintf("%d\tput %s=%s\tcli:%d seq:%d", ent.IID, op.Key, op.Val, op.Cli, op.Seq)
+	default:
+		return fmt.Sprintf("%d\tget %s\tcli:%d seq:%d", ent.IID, op.Key, op.Cli, op.Seq)
+	}
+}
@@ -0,0 +1,97 @@
+//paxosd : runs one paxos node of a cluster, until SIGTERM or SIGINT.
+//
+//	paxosd [-config cluster.cfg] [-id 1] [-data dir] [-listen host:port]
+//
+//All nodes may share one cluster file: a node takes its ID from -id,
+//else from $PAXOS_NODE_ID, else from the NodeID of the file. On SIGHUP
+//the file is read again and its Tuning taken, other changes need a
+//restart.
+package main
+
+import (
+	"flag"
+	"log"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/wilem/simple-paxos/config"
+	"github.com/wilem/simple-paxos/paxos"
+)
+
+//EnvNodeID : node ID when there is no -id flag.
+const EnvNodeID = "PAXOS_NODE_ID"
+
+func main() {
+	cfgFile := flag.String("config", "cluster.cfg", "ClusterConfig file of the cluster")
+	id := flag.Uint("id", 0, "node ID, 0: $"+EnvNodeID+", else NodeID of the config")
+	dataDir := flag.String("data", "", "directory of the node state, overrides DataDir")
+	listen := flag.String("listen", "", "host:port to listen on, overrides the own entry of Addrs")
+	flag.Parse()
+
+	nodeID := uint32(*id)
+	if env := os.Getenv(EnvNodeID); nodeID == 0 && env != "" {
+		v, err := strconv.ParseUint(env, 10, 32)
+		if err != nil {
+			log.Fatalf("%s=%q: %s\n", EnvNodeID, env, err)
+		}
+		nodeID = uint32(v)
+	}
+	load := func() (*config.ClusterConfig, error) {
+		cfg, err := config.LoadClusterConfig(*cfgFile, nodeID)
+		if err != nil {
+			return nil, err
+		}
+		if *dataDir != "" {
+			cfg.DataDir = *dataDir
+		}
+		if *listen != "" {
+			cfg.Listen = *listen
+		}
+		return cfg, nil
+	}
+	cfg, err := load()
+	if err != nil {
+		log.Fatalf("load config %s: %s\n", *cfgFile, err)
+	}
+
+	node, err := paxos.NewNodeCluster(cfg)
+	if err != nil {
+		log.Fatalf("config %s: %s\n", *cfgFile, err)
+	}
+	if err := node.Start(); err != nil {
+		log.Fatalf("[%d]start: %s\n", cfg.NodeID, err)
+	}
+	transName := cfg.Transport
+	if transName == "" {
+		transName = config.TransportUDP
+	}
+	listenAddr := cfg.Listen
+	if listenAddr == "" {
+		listenAddr = cfg.Addrs.Addr(cfg.NodeID)
+	}
+	log.Printf("[%d]started - config:%s hash:%016x roles:%s transport:%s listen:%s data:%q\n",
+		cfg.NodeID, *cfgFile, cfg.Hash(), strings.Join(node.Roles(), ","), transName, listenAddr, cfg.DataDir)
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGTERM, syscall.SIGINT, syscall.SIGHUP)
+	s := <-sig
+	for ; s == syscall.SIGHUP; s = <-sig {
+		newCfg, err := load()
+		if err == nil {
+			err = node.Reload(newCfg)
+		}
+		if err != nil {
+			log.Printf("[%d]reload config %s - err:%s\n", cfg.NodeID, *cfgFile, err)
+			continue
+		}
+		log.Printf("[%d]reloaded config %s\n", cfg.NodeID, *cfgFile)
+	}
+	log.Printf("[%d]stopping - signal:%s\n", cfg.NodeID, s)
+	if err := node.Stop(); err != nil {
+		log.Fatalf("[%d]stop: %s\n", cfg.NodeID, err)
+	}
+	log.Printf("[%d]stopped\n", cfg.NodeID)
+}
@@ -0,0 +1,206 @@
+package config
+
+import (
+	//"log"
+	"io/ioutil"
+	"encoding/json"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/wilem/simple-paxos/transport"
+	"github.com/wilem/simple-paxos/wire"
+)
+
+//ClusterConfig - config for cluster node.
+//One cluster file may serve all nodes: it leaves NodeID out, each node
+//gets its own by flag or env, see LoadClusterConfig.
+type ClusterConfig struct {
+	//node-local: NodeID, Listen, Secret, TLS*, DataDir, Tuning.
+	NodeID 		 uint32
+	//S = P + A + L
+	ServerList 	 []uint32
+	ProposerList []uint32
+	AcceptorList []uint32
+	LearnerList  []uint32
+	//nodes which run a client besides their roles; a node
+	//not in ServerList always does, whatever its ID.
+	ClientList []uint32 `json:",omitempty"`
+	//the lists above are the members of instance 1; a membership
+	//change chosen for instance i holds from i+Alpha on, so up to
+	//Alpha instances run at once. 0: no changes, the lists hold.
+	Alpha uint32 `json:",omitempty"`
+	//largest client Value, 0: DefaultMaxValueSize;
+	//values above one datagram are sent in fragments.
+	MaxValueSize uint32 `json:",omitempty"`
+	//timeouts, batching and the like, written inline.
+	Tuning
+	//peer links: TransportUDP (default) or TransportTCP
+	Transport string `json:",omitempty"`
+	//host:port of nodes, own listen address included;
+	//nodes not listed are on 127.0.0.1:500DD.
+	Addrs transport.AddrBook `json:",omitempty"`
+	//host:port the node listens on, "": its entry in Addrs.
+	Listen string `json:",omitempty"`
+	//shared by all nodes, frames are sealed with it and
+	//the ones which don't verify are dropped; "": off.
+	Secret string `json:",omitempty"`
+	//PEM files of the cluster CA bundle, node cert and key;
+	//with all set, TCP peer links use mutual TLS and the node
+	//cert has common name "node<NodeID>".
+	TLSCA   string `json:",omitempty"`
+	TLSCert string `json:",omitempty"`
+	TLSKey  string `json:",omitempty"`
+	//directory of node<NodeID>.state, a snapshot, and node<NodeID>.log,
+	//the records appended since; recovered on Start; "": kept in memory only.
+	DataDir string `json:",omitempty"`
+}
+
+//transport names of ClusterConfig.Transport
+const (
+	TransportUDP = "udp"
+	TransportTCP = "tcp"
+)
+
+// NewClusterConfig : 
+func NewClusterConfig(id uint32) *ClusterConfig {
+	c := new(ClusterConfig)
+	c.NodeID = id
+	return c
+}
+
+// SaveToFile : 
+func (c *ClusterConfig) SaveToFile(file string) error {
+	bs, err := json.Marshal(c)
+	if err != nil { return err }
+
+	err = ioutil.WriteFile(file, bs, os.FileMode(0777))
+	if err != nil { return err }
+	
+	return nil
+}
+
+// LoadFromFile : not validated, see Validate.
+func (c *ClusterConfig) LoadFromFile(file string) error {
+	bs, err := ioutil.ReadFile(file)
+	if err != nil { return err }
+	err = json.Unmarshal(bs, c)
+	if err != nil { return err }
+
+	return nil
+}
+//ErrInvalidConfig : a ClusterConfig fails Validate.
+var ErrInvalidConfig = errors.New("invalid cluster config")
+
+//MaxProposerID : highest ID of a proposer, a ballot holds 16 bits of it.
+const MaxProposerID = 0xFFFF
+
+//Validate : check the lists and settings make a cluster the node can
+//run in; returns all problems found at once, each one ErrInvalidConfig.
+func (c *ClusterConfig) Validate() error {
+	var errs []error
+	bad := func(format string, args ...interface{}) {
+		errs = append(errs, fmt.Errorf("%w: %s", ErrInvalidConfig, fmt.Sprintf(format, args...)))
+	}
+	servers := make(map[uint32]bool)
+	for _, id := range c.ServerList {
+		servers[id] = true
+	}
+	lists := []struct {
+		name   string
+		ids    []uint32
+		server bool //of servers only
+	}{
+		{"ServerList", c.ServerList, false},
+		{"ProposerList", c.ProposerList, true},
+		{"AcceptorList", c.AcceptorList, true},
+		{"LearnerList", c.LearnerList, true},
+		{"ClientList", c.ClientList, false},
+	}
+	for _, l := range lists {
+		seen := make(map[uint32]bool)
+		for _, id := range l.ids {
+			switch {
+			case id == 0:
+				bad("%s has node ID 0", l.name)
+			case seen[id]:
+				bad("%s lists node %d twice", l.name, id)
+			case l.server && !servers[id]:
+				bad("%s has node %d, not in ServerList", l.name, id)
+			}
+			seen[id] = true
+		}
+	}
+	if len(c.AcceptorList) == 0 {
+		bad("AcceptorList is empty, there is no quorum")
+	}
+	for _, id := range c.ProposerList {
+		if id > MaxProposerID {
+			bad("proposer %d above %d, ballots would not be unique", id, MaxProposerID)
+		}
+		if c.Alpha != 0 && !hasID(c.LearnerList, id) {
+			bad("proposer %d is no learner, with Alpha it must learn the membership changes", id)
+		}
+	}
+	if c.NodeID == 0 { //any other: outside ServerList, a client.
+		bad("NodeID is 0")
+	}
+	tls := c.TLSCA != "" || c.TLSCert != "" || c.TLSKey != ""
+	switch c.Transport {
+	case "", TransportUDP:
+		if tls {
+			bad("TLS needs Transport %q", TransportTCP)
+		}
+	case TransportTCP:
+	default:
+		bad("unknown Transport %q", c.Transport)
+	}
+	if tls && (c.TLSCA == "" || c.TLSCert == "" || c.TLSKey == "") {
+		bad("TLS needs all of TLSCA, TLSCert and TLSKey")
+	}
+	if c.MaxValueSize > wire.MaxValueSize {
+		bad("MaxValueSize %d above the wire limit %d", c.MaxValueSize, wire.MaxValueSize)
+	}
+	for _, p := range c.Tuning.problems() {
+		bad("%s", p)
+	}
+	return errors.Join(errs...)
+}
+
+//hasID : id is in lst.
+func hasID(lst []uint32, id uint32) bool {
+	for _, v := range lst {
+		if v == id {
+			return true
+		}
+	}
+	return false
+}
+
+//LoadClusterConfig : config of node id from file, which may be shared by
+//all nodes; id 0 keeps the NodeID of the file. Not validated.
+func LoadClusterConfig(file string, id uint32) (*ClusterConfig, error) {
+	c := NewClusterConfig(0)
+	if err := c.LoadFromFile(file); err != nil {
+		return nil, err
+	}
+	if id != 0 {
+		c.NodeID = id
+	}
+	return c, nil
+}
+
+//Hash : hash of the part of the config all nodes of the cluster must
+//agree on, node-local fields left out; the Secret too, the hash is sent
+//in the clear.
+func (c *ClusterConfig) Hash() uint64 {
+	h := *c
+	h.NodeID, h.Listen, h.DataDir = 0, "", ""
+	h.Secret, h.TLSCA, h.TLSCert, h.TLSKey = "", "", "", ""
+	h.Tuning = Tuning{}
+	bs, _ := json.Marshal(&h) //map keys of Addrs sorted
+	sum := sha256.Sum256(bs)
+	return binary.LittleEndian.Uint64(sum[:])
+}
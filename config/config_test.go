@@ -0,0 +1,148 @@
+package config
+
+import (
+	"testing"
+	"errors"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/wilem/simple-paxos/transport"
+)
+
+func TestClusterConfig(t *testing.T) {
+	
+	c := NewClusterConfig(1)
+	for i:= uint32(1); i <= 3; i++ {
+		c.ServerList = append(c.ServerList, i)
+		c.ProposerList = append(c.ProposerList, i)
+		c.AcceptorList = append(c.AcceptorList, i)
+		c.LearnerList = append(c.LearnerList, i)
+	}
+	
+	file := filepath.Join(t.TempDir(), "node1.cfg")
+	err := c.SaveToFile(file)
+	if err != nil {
+		t.Errorf("err:%s\n", err)
+	}
+	c1 := NewClusterConfig(0)
+
+	err = c1.LoadFromFile(file)
+	if err != nil {
+		t.Errorf("err:%s\n", err)
+	}
+	
+	log.Printf("c1:%+v\n", c1)
+}
+func TestClusterConfigAddrs(t *testing.T) {
+	c := NewClusterConfig(1)
+	c.Addrs = transport.AddrBook{1: "10.0.0.1:7000", 2: "node2.example:7000"}
+	file := filepath.Join(t.TempDir(), "node.cfg")
+	if err := c.SaveToFile(file); err != nil {
+		t.Fatal(err)
+	}
+	c1 := NewClusterConfig(0)
+	if err := c1.LoadFromFile(file); err != nil {
+		t.Fatal(err)
+	}
+	if c1.Addrs.Addr(2) != "node2.example:7000" || c1.Addrs.Addr(3) != "127.0.0.1:50003" {
+		t.Errorf("addrs:%+v\n", c1.Addrs)
+	}
+}
+
+func TestClusterConfigValidate(t *testing.T) {
+	c := NewClusterConfig(1)
+	c.ServerList = []uint32{1, 2, 3}
+	c.ProposerList = []uint32{1}
+	c.AcceptorList = []uint32{1, 2, 3}
+	c.LearnerList = []uint32{1, 2, 3}
+	if err := c.Validate(); err != nil {
+		t.Fatal("valid config:", err)
+	}
+	c.NodeID = 9 //client, listed or not
+	if err := c.Validate(); err != nil {
+		t.Fatal("valid client config:", err)
+	}
+	c.ClientList = []uint32{9}
+	if err := c.Validate(); err != nil {
+		t.Fatal("valid client config:", err)
+	}
+
+	bad := &ClusterConfig{
+		NodeID:       4,
+		ServerList:   []uint32{1, 2, 2},
+		ProposerList: []uint32{1, 5, 1<<16 + 1},
+		LearnerList:  []uint32{0},
+		Transport:    "quic",
+		TLSCert:      "node4.pem",
+	}
+	err := bad.Validate()
+	if !errors.Is(err, ErrInvalidConfig) {
+		t.Fatal("bad config:", err)
+	}
+	for _, want := range []string{
+		"ServerList lists node 2 twice",
+		"ProposerList has node 5, not in ServerList",
+		"proposer 65537 above 65535",
+		"LearnerList has node ID 0",
+		"AcceptorList is empty",
+		`unknown Transport "quic"`,
+		"TLS needs all of",
+	} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("missing %q in:\n%s", want, err)
+		}
+	}
+	if err := new(ClusterConfig).LoadFromFile(filepath.Join(t.TempDir(), "none.cfg")); !errors.Is(err, os.ErrNotExist) {
+		t.Error("load missing file:", err)
+	}
+
+	c.NodeID = 1
+	c.Alpha = 4 //membership changes: proposers learn them
+	c.LearnerList = []uint32{2, 3}
+	if err := c.Validate(); err == nil || !strings.Contains(err.Error(), "proposer 1 is no learner") {
+		t.Error("proposer not learning with Alpha:", err)
+	}
+	c.LearnerList = []uint32{1, 2, 3}
+	if err := c.Validate(); err != nil {
+		t.Error("valid config with Alpha:", err)
+	}
+}
+
+//TestClusterConfigShared : one cluster file for all nodes, the same
+//hash on each; a change to the cluster part changes it.
+func TestClusterConfigShared(t *testing.T) {
+	c := NewClusterConfig(0)
+	c.ServerList = []uint32{1, 2, 3}
+	c.AcceptorList = []uint32{1, 2, 3}
+	c.Addrs = transport.AddrBook{1: "10.0.0.1:7000", 2: "10.0.0.2:7000", 3: "10.0.0.3:7000"}
+	file := filepath.Join(t.TempDir(), "cluster.cfg")
+	if err := c.SaveToFile(file); err != nil {
+		t.Fatal(err)
+	}
+	c1, err := LoadClusterConfig(file, 1)
+	if err != nil {
+		t.Fatal("LoadClusterConfig:", err)
+	}
+	c2, _ := LoadClusterConfig(file, 2)
+	if c1.NodeID != 1 || c2.NodeID != 2 {
+		t.Fatal("node IDs:", c1.NodeID, c2.NodeID)
+	}
+	c2.Listen, c2.DataDir, c2.Secret = ":7000", "/var/lib/paxos", "s3cret"
+	if c1.Hash() != c2.Hash() {
+		t.Error("node-local fields change the hash")
+	}
+	c2.Addrs[3] = "10.0.0.4:7000"
+	if c1.Hash() == c2.Hash() {
+		t.Error("Addrs change not in the hash")
+	}
+	c2.Addrs[3] = "10.0.0.3:7000"
+	c2.AcceptorList = []uint32{1, 2}
+	if c1.Hash() == c2.Hash() {
+		t.Error("AcceptorList change not in the hash")
+	}
+	if _, err := LoadClusterConfig(filepath.Join(t.TempDir(), "none.cfg"), 1); !errors.Is(err, os.ErrNotExist) {
+		t.Error("missing file:", err)
+	}
+}
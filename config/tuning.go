@@ -0,0 +1,84 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"time"
+)
+
+//Duration : time.Duration, as a string such as "200ms" in config files.
+type Duration time.Duration
+
+//String : as time.Duration does.
+func (d Duration) String() string {
+	return time.Duration(d).String()
+}
+
+//MarshalJSON :
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.String())
+}
+
+//UnmarshalJSON :
+func (d *Duration) UnmarshalJSON(bs []byte) error {
+	var s string
+	if err := json.Unmarshal(bs, &s); err != nil {
+		return fmt.Errorf("duration %s: want a string such as \"200ms\"", bs)
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+//Tuning : protocol timing and sizes of a node; 0 takes the one of
+//DefaultTuning. Node-local: nodes may differ, a running node may
+//reload them, they are not in the config hash.
+type Tuning struct {
+	//a client call or query not answered in time goes to the next server.
+	ClientTimeout Duration `json:",omitempty"`
+	//an instance not chosen in time is retried with a higher ballot,
+	//after a random wait in [RetryTimeout, 2*RetryTimeout).
+	RetryTimeout Duration `json:",omitempty"`
+}
+
+//DefaultTuning : what a node runs with when the config leaves it out.
+//Instances in progress at once are bounded by ClusterConfig.Alpha.
+var DefaultTuning = Tuning{
+	ClientTimeout: Duration(time.Second),
+	RetryTimeout:  Duration(200 * time.Millisecond),
+}
+
+//WithDefaults : t, DefaultTuning for what is 0.
+func (t Tuning) WithDefaults() Tuning {
+	dv := reflect.ValueOf(DefaultTuning)
+	tv := reflect.ValueOf(&t).Elem()
+	for i := 0; i < tv.NumField(); i++ {
+		if tv.Field(i).IsZero() {
+			tv.Field(i).Set(dv.Field(i))
+		}
+	}
+	return t
+}
+
+//problems : what is wrong with t.
+func (t Tuning) problems() (lst []string) {
+	v := reflect.ValueOf(t)
+	for i := 0; i < v.NumField(); i++ {
+		if d, ok := v.Field(i).Interface().(Duration); ok && d < 0 {
+			lst = append(lst, fmt.Sprintf("%s is negative", v.Type().Field(i).Name))
+		}
+	}
+	return
+}
+
+//Reloadable : o differs from c in Tuning only, which a running node
+//may take; the rest needs a restart.
+func (c *ClusterConfig) Reloadable(o *ClusterConfig) bool {
+	a, b := *c, *o
+	a.Tuning, b.Tuning = Tuning{}, Tuning{}
+	return reflect.DeepEqual(a, b)
+}
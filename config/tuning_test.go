@@ -0,0 +1,81 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTuning(t *testing.T) {
+	c := NewClusterConfig(1)
+	c.ServerList = []uint32{1}
+	c.AcceptorList = []uint32{1}
+	h := c.Hash()
+	c.RetryTimeout = Duration(50 * time.Millisecond)
+	file := filepath.Join(t.TempDir(), "cluster.cfg")
+	if err := c.SaveToFile(file); err != nil {
+		t.Fatal(err)
+	}
+	c1, err := LoadClusterConfig(file, 1)
+	if err != nil {
+		t.Fatal("LoadClusterConfig:", err)
+	}
+	if c1.Tuning != c.Tuning {
+		t.Errorf("tuning: %+v", c1.Tuning)
+	}
+	if c1.Hash() != h {
+		t.Error("tuning changes the hash")
+	}
+	tun := c1.Tuning.WithDefaults()
+	if tun.RetryTimeout != c.RetryTimeout || tun.ClientTimeout != DefaultTuning.ClientTimeout {
+		t.Errorf("with defaults: %+v", tun)
+	}
+	if !c.Reloadable(c1) {
+		t.Error("same config not reloadable")
+	}
+	c1.RetryTimeout = Duration(time.Second)
+	if !c.Reloadable(c1) {
+		t.Error("tuning change not reloadable")
+	}
+	c1.AcceptorList = []uint32{1, 2}
+	if c.Reloadable(c1) {
+		t.Error("AcceptorList change reloadable")
+	}
+
+	var d Duration
+	if err := json.Unmarshal([]byte(`"1m30s"`), &d); err != nil || d != Duration(90*time.Second) {
+		t.Error("duration:", d, err)
+	}
+	if err := json.Unmarshal([]byte(`200`), &d); err == nil {
+		t.Error("duration without unit taken")
+	}
+}
+
+func TestTuningValidate(t *testing.T) {
+	c := NewClusterConfig(1)
+	c.ServerList = []uint32{1}
+	c.AcceptorList = []uint32{1}
+	c.RetryTimeout = Duration(-time.Second)
+	c.MaxValueSize = 1 << 30
+	err := c.Validate()
+	if !errors.Is(err, ErrInvalidConfig) {
+		t.Fatal("bad tuning:", err)
+	}
+	for _, want := range []string{
+		"RetryTimeout is negative",
+		"MaxValueSize 1073741824 above",
+	} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("missing %q in: %s", want, err)
+		}
+	}
+	c.Tuning = Tuning{ClientTimeout: DefaultTuning.ClientTimeout} //default spelled out
+	c.RetryTimeout = Duration(time.Second)
+	c.MaxValueSize = 0
+	if err := c.Validate(); err != nil {
+		t.Error("valid tuning:", err)
+	}
+}
@@ -0,0 +1,3 @@
+module github.com/wilem/simple-paxos
+
+go 1.22
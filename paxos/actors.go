@@ -0,0 +1,876 @@
+package paxos
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/wilem/simple-paxos/storage"
+	"github.com/wilem/simple-paxos/wire"
+)
+
+//Actor : Client/Proposer/Acceptor/Learner
+type Actor interface {
+	getID() uint32
+}
+
+//Client :
+type Client struct {
+	node *Node
+	seq  uint32       //seq of last call
+	dst  uint32       //proposer calls go to, 0: not known yet
+	call *clientCall  //in flight, nil if idle
+	qseq uint32       //seq of last query
+	qdst uint32       //learner queries go to, 0: not known yet
+	qry  *clientQuery //in flight, nil if idle
+}
+
+//clientCall : request waiting for its response.
+type clientCall struct {
+	seq   uint32
+	bs    []byte //encoded request
+	timer Timer
+	done  func(ret int, res *wire.Value)
+}
+
+//clientQuery : query of the log waiting for its reply.
+type clientQuery struct {
+	seq   uint32
+	bs    []byte //encoded query
+	timer Timer
+	done  func(ret int, ent *LogEntry)
+}
+
+//LogEntry : instance of the log, as the learner which answered knows it.
+type LogEntry struct {
+	IID    uint32
+	Val    *wire.Value //chosen value, nil if not chosen yet
+	Next   uint32      //1st instance the learner has not applied
+	Leader uint32      //leader proposer, 0: not known
+	From   uint32      //learner which answered
+}
+
+//ErrClientBusy : a call is in flight already.
+var ErrClientBusy = errors.New("client: call in flight")
+
+//ErrNoClient : node has no client role.
+var ErrNoClient = errors.New("client: not a client node")
+
+//ErrNotACall : value is no call of the client with its next seq, the
+//learners would answer nothing.
+var ErrNotACall = errors.New("client: value is no call of this client")
+
+//NewClient :
+func NewClient(node *Node) *Client {
+	c := new(Client)
+	c.node = node
+	return c
+}
+
+//Start :
+func (c *Client) Start() error {
+	return nil
+}
+
+//Stop : a call or query in flight ends with PxsStatusClusterUnavailable.
+func (c *Client) Stop() error {
+	if qry := c.qry; qry != nil {
+		qry.timer.Stop()
+		c.qry = nil
+		if qry.done != nil {
+			qry.done(int(PxsStatusClusterUnavailable), &LogEntry{})
+		}
+	}
+	call := c.call
+	if call == nil {
+		return nil
+	}
+	call.timer.Stop()
+	c.call = nil
+	if call.done != nil {
+		call.done(int(PxsStatusClusterUnavailable), &wire.Value{})
+	}
+	return nil
+}
+
+//Call : submit val with the next seq; done gets the status and result
+//once val is chosen and applied. Until then the call is sent to one
+//proposer after another. One call at a time. val is a KVOp or MemberOp
+//of this client with the next seq, as Do and Reconfigure make them.
+func (c *Client) Call(val *wire.Value, done func(ret int, res *wire.Value)) (seq uint32, err error) {
+	if c.call != nil {
+		return 0, ErrClientBusy
+	}
+	if max := c.node.maxValueSize(); val.Size() > max {
+		return 0, fmt.Errorf("%w: %d > %d", wire.ErrValueTooLarge, val.Size(), max)
+	}
+	if cli, seq, ok := callOf(val); !ok || cli != c.node.id || seq != c.seq+1 {
+		return 0, fmt.Errorf("%w: cli:%d, seq:%d, want %d,%d", ErrNotACall, cli, seq, c.node.id, c.seq+1)
+	}
+	bs, err := wire.NewPxsMsgRequest(c.seq+1, val).Encode()
+	if err != nil {
+		return 0, err
+	}
+	//a seq used before a crash, used again, would be taken for a retry.
+	if err = c.node.logRecords(storage.Record{ClientSeq: c.seq + 1}); err != nil {
+		return 0, err
+	}
+	c.seq++
+	c.call = &clientCall{seq: c.seq, bs: bs, done: done}
+	if c.dst == 0 {
+		c.dst = DefaultLeaderNodeID
+	}
+	c.send()
+	return c.seq, nil
+}
+
+//callOf : client and seq of the call val is, false if it is none.
+func callOf(val *wire.Value) (cli, seq uint32, ok bool) {
+	if op, err := DecodeMemberOp(val); err == nil {
+		return op.Cli, op.Seq, true
+	}
+	if op, err := DecodeKVOp(val); err == nil {
+		return op.Cli, op.Seq, true
+	}
+	return 0, 0, false
+}
+
+//Reconfigure : make membership change op, as Cli with the next seq; done
+//gets PxsStatusMemberOpRejected and why, or the iid it holds from.
+func (c *Client) Reconfigure(op *MemberOp, done func(ret int, res string)) error {
+	op.Cli, op.Seq = c.node.id, c.seq+1
+	_, err := c.Call(op.Value(), func(ret int, res *wire.Value) { done(ret, string(res.Oct)) })
+	return err
+}
+
+//Do : call op of the KV state machine, as Cli with the next seq.
+func (c *Client) Do(op *KVOp, done func(ret int, res string)) error {
+	op.Cli, op.Seq = c.node.id, c.seq+1
+	_, err := c.Call(op.Value(), func(ret int, res *wire.Value) { done(ret, string(res.Oct)) })
+	return err
+}
+
+//send : send the call in flight to dst, retry on the next proposer
+//if not answered in time.
+func (c *Client) send() {
+	call := c.call
+	c.node.SendTo(c.dst, call.bs)
+	call.timer = c.node.afterFunc(time.Duration(c.node.tuning.ClientTimeout), func() {
+		if c.call != call {
+			return
+		}
+		c.dst = c.nextProposer()
+		log.Printf("[%d]Client timeout - seq:%d, retry on %d\n", c.node.id, call.seq, c.dst)
+		c.send()
+	})
+}
+
+//nextProposer : proposer after dst in config.
+func (c *Client) nextProposer() uint32 {
+	lst := c.node.cfg.ProposerList
+	for i, id := range lst {
+		if id == c.dst {
+			return lst[(i+1)%len(lst)]
+		}
+	}
+	if len(lst) == 0 {
+		return DefaultLeaderNodeID
+	}
+	return lst[0]
+}
+
+//Query : ask a learner for the entry of the log at iid, iid 0 for its
+//state only; done gets PxsStatusOK if a value is chosen, else
+//PxsStatusNotChosen. Until answered the query is sent to one learner
+//after another. One query at a time, besides a call.
+func (c *Client) Query(iid uint32, done func(ret int, ent *LogEntry)) error {
+	if c.qry != nil {
+		return ErrClientBusy
+	}
+	c.qseq++
+	bs, _ := wire.NewPxsMsgQuery(iid, c.qseq).Encode()
+	c.qry = &clientQuery{seq: c.qseq, bs: bs, done: done}
+	if c.qdst == 0 {
+		c.qdst = c.nextLearner()
+	}
+	c.sendQuery()
+	return nil
+}
+
+//sendQuery : send the query in flight to qdst, retry on the next
+//learner if not answered in time.
+func (c *Client) sendQuery() {
+	qry := c.qry
+	c.node.SendTo(c.qdst, qry.bs)
+	qry.timer = c.node.afterFunc(time.Duration(c.node.tuning.ClientTimeout), func() {
+		if c.qry != qry {
+			return
+		}
+		c.qdst = c.nextLearner()
+		log.Printf("[%d]Client query timeout - seq:%d, retry on %d\n", c.node.id, qry.seq, c.qdst)
+		c.sendQuery()
+	})
+}
+
+//nextLearner : learner after qdst in config.
+func (c *Client) nextLearner() uint32 {
+	lst := c.node.cfg.LearnerList
+	for i, id := range lst {
+		if id == c.qdst {
+			return lst[(i+1)%len(lst)]
+		}
+	}
+	if len(lst) == 0 {
+		return DefaultLeaderNodeID
+	}
+	return lst[0]
+}
+
+//OnRecvLog : reply of a learner to the query in flight.
+func (c *Client) OnRecvLog(lg *wire.PxsMsgLog, from uint32) {
+	qry := c.qry
+	if qry == nil || qry.seq != lg.Seq { //late or duplicated
+		return
+	}
+	qry.timer.Stop()
+	c.qry = nil
+	c.qdst = from //answered, stick to it.
+	ent := &LogEntry{IID: lg.Hdr.IID, Next: lg.Next, Leader: lg.Leader, From: from}
+	if lg.Ret == uint32(PxsStatusOK) {
+		ent.Val = &lg.Val
+	}
+	if qry.done != nil {
+		qry.done(int(lg.Ret), ent)
+	}
+}
+
+//DefaultLeaderNodeID :
+const DefaultLeaderNodeID uint32 = 1
+
+//Submit : send value to proposer, return errno and error.
+func (c *Client) Submit(seq uint32, val *wire.Value) (int, error) {
+	if max := c.node.maxValueSize(); val.Size() > max {
+		return int(PxsStatusValueTooLarge),
+			fmt.Errorf("%w: %d > %d", wire.ErrValueTooLarge, val.Size(), max)
+	}
+	dst := DefaultLeaderNodeID //c.node.leaderID//default leader node id
+	msg := wire.NewPxsMsgRequest(seq, val)
+	oct, err := msg.Encode()
+	if err != nil {
+		return -1, err
+	}
+	return c.node.SendTo(dst, oct)
+}
+
+//OnRecvResponse : on recv response from acceptors
+func (c *Client) OnRecvResponse(rsp *wire.PxsMsgResponse, from uint32) (ret int) {
+	log.Printf("[%d]Client.OnRecvResponse - rsp:%+v, from:%d, ret:%d\n",
+		c.node.id, rsp, from, rsp.Ret)
+	call := c.call
+	if call == nil || call.seq != rsp.Hdr.IID { //late or duplicated
+		return 0
+	}
+	call.timer.Stop()
+	c.call = nil
+	c.dst = from //answered, stick to it.
+	if call.done != nil {
+		call.done(int(rsp.Ret), &rsp.Val)
+	}
+	return 0
+}
+
+//Proposer :
+type Proposer struct {
+	node *Node
+	p1a  map[uint32]uint32 //iid -> bal
+	//iid -> bal
+	//delete this entry after iid is chosen;
+	pendingList []*wire.PxsMsgRequest
+	//acceptor state
+	//maxBal  map[[2]uint32]uint32         //[iid,acc] -> mBal
+	//maxVBal map[[2]uint32]uint32         //[iid,acc] -> mVBal
+	//maxVal  map[[2]uint32]*Value         //[iid,acc] -> mVal
+	p1b       map[[2]uint32]*wire.PxsMsgPromise  //[iid,acc] -> P1b{bal,vbal,val}
+	p2a       map[[2]uint32]*wire.PxsMsgAccept   //[iid,bal] -> P2a{bal,val}
+	p2b       map[[2]uint32]*wire.PxsMsgAccepted //[iid,acc] -> P2b{bal,val}
+	nPromised map[uint32]uint32                  //iid -> nPromised
+	nAccepted map[uint32]uint32                  //iid -> nAccepted
+	phase     map[uint32]uint32                  //pxsPhaseState
+	gotOldVal map[[2]uint32]bool                 //[iid,bal] -> gotOldVal; got old value from acceptors
+	curIID    uint32                             //instance being proposed, 0: idle
+	timer     Timer                              //retry of curIID
+	waiting   map[[2]uint32]uint32               //[cli,seq] -> node to respond to once applied
+}
+
+//proposer : algorithm phase
+const (
+	pxsPhaseIdle              uint32 = 0
+	pxsPhaseSendPrepare       uint32 = 10
+	pxsPhaseQuorumPromised    uint32 = 20
+	pxsPhaseSendAccept        uint32 = 30 //send accept
+	pxsPhaseSendAcceptNew     uint32 = 31 //send accept with new value from pendingList;
+	pxsPhaseSendAcceptOld     uint32 = 32 //send accept with old value from acceptors;
+	pxsPhaseQuorumAccepted    uint32 = 40 //accepted
+	pxsPhaseQuorumAcceptedNew uint32 = 41 //accepted new value
+	pxsPhaseQuorumAcceptedOld uint32 = 42 //accepted old value
+	pxsPhaseSendCommit        uint32 = 50 //commit value
+	pxsPhaseSendCommitNew     uint32 = 51 //commit new value
+	pxsPhaseSendCommitOld     uint32 = 52 //commit old value
+	pxsPhaseCommitted         uint32 = 60 //committed to RSM
+)
+
+//NewProposer :
+func NewProposer(node *Node) *Proposer {
+	p := new(Proposer)
+	p.node = node
+	p.p1a = make(map[uint32]uint32)
+	p.p1b = make(map[[2]uint32]*wire.PxsMsgPromise)
+	p.p2a = make(map[[2]uint32]*wire.PxsMsgAccept)
+	p.p2b = make(map[[2]uint32]*wire.PxsMsgAccepted)
+	p.nPromised = make(map[uint32]uint32)
+	p.nAccepted = make(map[uint32]uint32)
+	p.phase = make(map[uint32]uint32)
+	p.gotOldVal = make(map[[2]uint32]bool)
+	p.waiting = make(map[[2]uint32]uint32)
+	return p
+}
+
+//Start :
+func (p *Proposer) Start() error {
+	//TODO start election timer
+	return nil
+}
+
+//Stop : give up the instance in progress; values pending are dropped,
+//their clients retry.
+func (p *Proposer) Stop() error {
+	if p.timer != nil {
+		p.timer.Stop()
+		p.timer = nil
+	}
+	p.curIID = 0
+	return nil
+}
+
+//getNextBallot : select a ballot number, unique among proposers:
+//round<<16 | node ID; higher node ID wins within a round. Proposer IDs
+//are at most config.MaxProposerID.
+func (p *Proposer) getNextBallot(iid uint32) uint32 {
+	round := p.p1a[iid]>>16 + 1
+	p.p1a[iid] = round<<16 | p.node.id&0xFFFF
+	return p.p1a[iid]
+}
+
+//SendToAllAcceptors : send one msg to all acceptors of iid.
+func SendToAllAcceptors(node *Node, iid uint32, bs []byte) (ret int) {
+	var nok uint32
+	members := node.members.at(iid)
+	for _, id := range members.Acceptors {
+		nwr, err := node.SendTo(id, bs)
+		if nwr == len(bs) {
+			nok++
+		} else {
+			ret = nwr
+			log.Printf("[%d]node.SendTo - ret:%d, err:%s\n", node.id, nwr, err)
+		}
+	}
+	//check cluster status
+	if nok < members.quorum() {
+		log.Printf("[%d]node.SendTo - nok:%d, cluster is not ready.\n", node.id, nok)
+		return int(PxsStatusClusterUnavailable)
+	}
+	return 0
+}
+
+//OnRecvRequest : client requests.
+func (p *Proposer) OnRecvRequest(req *wire.PxsMsgRequest, from uint32) (ret int) {
+	log.Printf("[%d]Proposer.OnRecvRequest - req:%+v, from:%d\n", p.node.id, req, from)
+	if req.Val.Size() > p.node.maxValueSize() {
+		log.Printf("[%d]value too large - siz:%d\n", p.node.id, req.Val.Size())
+		return int(PxsStatusValueTooLarge)
+	}
+	if !hasID(p.node.members.latest().Proposers, p.node.id) { //removed, client goes on to the next.
+		log.Printf("[%d]not a proposer anymore - req:%+v dropped\n", p.node.id, req)
+		return 0
+	}
+	//1. enqueue pending list, once.
+	key := [2]uint32{from, req.Hdr.IID}
+	if _, ok := p.waiting[key]; ok { //retried
+		return 0
+	}
+	p.waiting[key] = from
+	p.pendingList = append(p.pendingList, req) //dequeue once value is chose
+	if p.curIID != 0 {                         //proposed once curIID is chosen.
+		return 0
+	}
+	return p.propose()
+}
+
+//propose : run a new instance for the 1st pending value.
+func (p *Proposer) propose() (ret int) {
+	iid := p.node.instanceID   //nextInstanceID, updated once chosen.
+	if p.node.learner != nil { //fill holes of the log first.
+		iid = p.node.learner.nextHole()
+	}
+	//in the alpha window: iid is the 1st not applied, so all member
+	//ops of its members are.
+	p.curIID = iid
+	defer p.armTimer(iid)
+	//TODO skip phase 1 while leader: only safe once a quorum promised
+	//the leader ballot for every instance from iid on (multi-paxos);
+	//an accept sent with a fresh ballot may overwrite a chosen value.
+	return p.prepare(iid)
+}
+
+//prepare : phase 1 of iid with a new ballot.
+func (p *Proposer) prepare(iid uint32) (ret int) {
+	bal := p.getNextBallot(iid)
+	p1a := wire.NewPxsMsgPrepare(iid, bal)
+	bs, _ := p1a.Encode()
+
+	log.Printf("[%d]Proposer.SendPrepare - p1a:%+v\n", p.node.id, p1a)
+
+	ret = SendToAllAcceptors(p.node, iid, bs)
+	p.phase[iid] = pxsPhaseSendPrepare
+	return
+}
+
+//armTimer : retry iid unless it is chosen in time.
+func (p *Proposer) armTimer(iid uint32) {
+	if p.timer != nil {
+		p.timer.Stop()
+	}
+	bal := p.p1a[iid]
+	retry := time.Duration(p.node.tuning.RetryTimeout) //see config.Tuning
+	d := retry + time.Duration(p.node.rng.Int63n(int64(retry)))
+	p.timer = p.node.afterFunc(d, func() { p.onTimeout(iid, bal) })
+}
+
+//onTimeout : iid is not chosen with bal in time, start over with phase 1.
+func (p *Proposer) onTimeout(iid, bal uint32) {
+	if p.curIID != iid || p.p1a[iid] != bal { //stale timer
+		return
+	}
+	log.Printf("[%d]Proposer timeout - iid:%d, bal:%d, phase:%d\n", p.node.id, iid, bal, p.phase[iid])
+	p.prepare(iid)
+	p.armTimer(iid)
+}
+
+//OnRecvPromise : from acceptors
+func (p *Proposer) OnRecvPromise(pro *wire.PxsMsgPromise, from uint32) (ret int) {
+	log.Printf("[%d]Proposer.OnRecvPromise - pro:%+v, acc:%d\n", p.node.id, pro, from)
+	iid := pro.Hdr.IID
+	acc := pro.Acc
+	bal := pro.Bal
+	//0. reject unmatch ballot, and promises late for an iid learned
+	if iid != p.curIID || (p.node.learner != nil && p.node.learner.chosen[iid] != nil) {
+		log.Printf("[%d]drop promise, iid:%d not proposed, acc:%d\n", p.node.id, iid, acc)
+		return -1
+	}
+	if bal != p.p1a[iid] {
+		log.Printf("[%d]drop unmatch ballot:%d, acc:%d, iid:%d\n", p.node.id, bal, acc, iid)
+		return -1 //XXX
+	}
+	//1. p1b msg enqueue
+	iidAcc := [2]uint32{iid, acc} //XXX
+	p.p1b[iidAcc] = pro
+	//2. check phase state
+	if p.phase[iid] != pxsPhaseSendPrepare &&
+		p.phase[iid] != pxsPhaseQuorumPromised { //XXX
+		log.Printf("[%d]drop unexpected promise, phase:%d\n", p.node.id, p.phase[iid])
+		return -1 //XXX
+	}
+	//3. check quorum, of promises for this ballot only, by acceptors of iid.
+	members := p.node.members.at(iid)
+	var nPromised uint32
+	for k, m := range p.p1b {
+		if k[0] == iid && m.Bal == bal && hasID(members.Acceptors, k[1]) {
+			nPromised++
+		}
+	}
+	p.nPromised[iid] = nPromised
+	if nPromised >= members.quorum() { //got quorum
+		log.Printf("[%d]Proposer - got quorum.", p.node.id)
+		if p.phase[iid] == pxsPhaseSendPrepare {
+			p.phase[iid] = pxsPhaseQuorumPromised
+			return p.Phase2(iid, bal)
+		}
+	}
+	return 0
+}
+
+//Phase2 : proposer carry out phase 2, assum phase is promised;
+func (p *Proposer) Phase2(iid, bal uint32) (ret int) {
+	var maxVBal uint32
+	var msg *wire.PxsMsgPromise
+	var val *wire.Value
+
+	//1. try to find old value from promises
+	members := p.node.members.at(iid)
+	for k, m := range p.p1b {
+		if k[0] == iid && m.Bal == bal && hasID(members.Acceptors, k[1]) {
+			if m.MVBal != wire.Invalidballot && m.MVBal > maxVBal {
+				maxVBal = m.MVBal
+				msg = m
+			}
+		}
+	}
+
+	//2. found old value
+	if msg != nil {
+		p.gotOldVal[[2]uint32{iid, bal}] = true
+		val = &msg.MVal
+		//bal := msg.bal //assert
+		log.Printf("[%d]Phase2 - iid:%d, old value:%+v\n", p.node.id, iid, val)
+	} else if len(p.pendingList) > 0 {
+		//2.1 send new value
+		val = &p.pendingList[0].Val
+	} else {
+		log.Printf("[%d]Phase2 - iid:%d, no value to propose\n", p.node.id, iid)
+		return -1
+	}
+
+	//3. send accept
+	p2a := wire.NewPxsMsgAccept(iid, bal, val)
+	bs, _ := p2a.Encode()
+
+	log.Printf("[%d]Proposer.SendAccept: %+v\n", p.node.id, p2a)
+
+	ret = SendToAllAcceptors(p.node, iid, bs)
+	if ret == 0 {
+		p.phase[iid] = pxsPhaseSendAccept
+		iidBal := [2]uint32{iid, bal}
+		p.p2a[iidBal] = p2a
+		log.Printf("[%d]phase2: iidBal:%+v, p2a:%+v\n", p.node.id, iidBal, p2a)
+	} else {
+		log.Printf("Failed: SendToAllAcceptors\n")
+	}
+
+	return
+}
+
+//OnRecvAccepted : from acceptor
+func (p *Proposer) OnRecvAccepted(acd *wire.PxsMsgAccepted, from uint32) (ret int) {
+	log.Printf("[%d]Proposer.OnRecvAccepted - from:%d, p2b:%+v\n", p.node.id, from, acd)
+	iid := acd.Hdr.IID
+	bal := acd.Bal
+	acc := acd.Acc
+
+	if acc != from {
+		log.Printf("[%d]Unmatch acceptor id - acc:%d != from:%d\n", p.node.id, acc, from)
+		return -1
+	}
+
+	//0. check ballot and value
+	iidBal := [2]uint32{iid, bal}
+	_, ok := p.p2a[iidBal]
+	if !ok {
+		log.Printf("[%d]Unmatch p2a - iidBal:%+v\n", p.node.id, iidBal)
+		return -1
+	}
+	//TODO check value: p2a.val == acd.val
+
+	//1. check phase state
+	if p.phase[iid] != pxsPhaseSendAccept {
+		log.Printf("[%d]Unexpected p2b - iid:%d, bal:%d; phase:%d\n",
+			p.node.id, iid, bal, p.phase[iid])
+		return -1
+	}
+
+	//2. check quorum, of acceptors of iid.
+	iidAcc := [2]uint32{iid, acc}
+	p.p2b[iidAcc] = acd
+	members := p.node.members.at(iid)
+	var nrsp uint32
+	for k, m := range p.p2b {
+		if k[0] == iid && m.Bal == bal && hasID(members.Acceptors, k[1]) {
+			nrsp++
+		}
+	}
+	if nrsp >= members.quorum() {
+		if p.phase[iid] == pxsPhaseSendAccept {
+			p.phase[iid] = pxsPhaseQuorumAccepted
+			log.Printf("[%d]Accepted got quorum - iid:%d,bal:%d,nrsp:%d\n", p.node.id, iid, bal, nrsp)
+			//send commit
+			cmt := wire.NewPxsMsgCommit(iid, bal, &p.p2a[[2]uint32{iid, bal}].Val)
+			bs, _ := cmt.Encode()
+			ret = SendToAllAcceptors(p.node, iid, bs)
+			for _, id := range members.Learners { //the ones which are no acceptor
+				if !hasID(members.Acceptors, id) {
+					p.node.SendTo(id, bs)
+				}
+			}
+			if op, err := DecodeMemberOp(&cmt.Val); err == nil && op.Op == MemberOpAdd && op.Role == RoleLearner &&
+				!hasID(members.Acceptors, op.ID) && !hasID(members.Learners, op.ID) {
+				p.node.SendTo(op.ID, bs) //a learner to be, it takes the role.
+			}
+			//chosen, whether commit is sent or not.
+			p.phase[iid] = pxsPhaseSendCommit
+			//TODO cleanup p1a,p2a,cmt msgs
+			//TODO execute RSM
+			p.onChosen(iid, bal)
+		}
+	}
+
+	return //ret
+}
+
+//onChosen : value of p2a[iid,bal] is chosen.
+func (p *Proposer) onChosen(iid, bal uint32) {
+	val := &p.p2a[[2]uint32{iid, bal}].Val
+	if p.node.learner != nil {
+		p.node.learner.learn(iid, val) //back in onLearned
+		return
+	}
+	p.onLearned(iid, val)
+}
+
+//onLearned : val is chosen for iid, go on with the next instance.
+func (p *Proposer) onLearned(iid uint32, val *wire.Value) {
+	if p.node.instanceID <= iid {
+		p.node.instanceID = iid + 1
+	}
+	p.phase[iid] = pxsPhaseCommitted //done, late msgs of iid are dropped
+	delete(p.p1a, iid)
+	if p.curIID != iid {
+		return
+	}
+	p.curIID = 0
+	if p.timer != nil {
+		p.timer.Stop()
+		p.timer = nil
+	}
+	//an old value may have been chosen instead of ours.
+	pop := len(p.pendingList) > 0 && bytes.Equal(val.Oct, p.pendingList[0].Val.Oct)
+	p.runPendingList(pop)
+}
+
+//onApplied : call seq of client cli is applied with status ret and result res.
+func (p *Proposer) onApplied(cli, seq uint32, ret PxsStatus, res *wire.Value) {
+	key := [2]uint32{cli, seq}
+	to, ok := p.waiting[key]
+	if !ok {
+		return
+	}
+	delete(p.waiting, key)
+	bs, _ := wire.NewPxsMsgResponse(seq, uint32(ret), res).Encode()
+	p.node.SendTo(to, bs)
+}
+
+//runPendingList : clean up and run pendingList;
+func (p *Proposer) runPendingList(pop bool) {
+	if pop { // 1st element.
+		p.pendingList = p.pendingList[1:]
+	}
+	if len(p.pendingList) > 0 {
+		p.propose()
+	}
+}
+
+//Acceptor :
+type Acceptor struct {
+	node    *Node
+	maxBal  map[uint32]uint32      //iid -> mBal; highest ballot promised;
+	maxVBal map[uint32]uint32      //iid -> mVBal; highet ballot accepted;
+	maxVal  map[uint32]*wire.Value //iid -> mVal; value with the maxVBal;
+}
+
+//NewAcceptor :
+func NewAcceptor(node *Node) *Acceptor {
+	a := new(Acceptor)
+	a.node = node
+	a.maxBal = make(map[uint32]uint32)
+	a.maxVBal = make(map[uint32]uint32)
+	a.maxVal = make(map[uint32]*wire.Value)
+	return a
+}
+
+//Start :
+func (a *Acceptor) Start() error {
+	return nil
+}
+
+//OnRecvPrepare : from proposer
+func (a *Acceptor) OnRecvPrepare(p1a *wire.PxsMsgPrepare, from uint32) (int, error) {
+	//var ret int
+	iid := p1a.Hdr.IID
+	bal := p1a.Bal
+	//p1b:
+	if bal > a.maxBal[iid] { /*maxBal = 0, if not found*/
+		a.maxBal[iid] = bal       //promised ballot
+		var vbal uint32           //accepted ballot
+		var val *wire.Value       //accepted value
+		if a.maxVal[iid] != nil { //have old value
+			vbal = a.maxVBal[iid] //have voted ballot
+			val = a.maxVal[iid]   //have voted value
+		} else {
+			// without voted value
+			vbal = wire.Invalidballot //without voted ballot
+			val = &wire.Value{}       //without voted value
+		}
+		if err := a.node.logRecords(a.record(iid)); err != nil { //promise kept before told
+			return int(PxsStatusStorageFailure), err
+		}
+		p1b := wire.NewPxsMsgPromise(iid, a.node.id, bal, vbal, val)
+		bs, _ := p1b.Encode()
+		nwr, err := a.node.SendTo(from, bs)
+		if nwr != len(bs) {
+			log.Printf("[%d]node.SendTo failed - nwr:%d, len(bs):%d\n",
+				a.node.id, nwr, len(bs))
+			return int(PxsStatusNetworkIOFailure), err
+		}
+
+		return 0, nil
+	}
+	//drop lower ballot p1a msg.
+	log.Printf("[%d]DROPPED msg - p1a:%+v\n, from:%d\n", a.node.id, p1a, from)
+	return 0, nil
+}
+
+//OnRecvAccept : from proposer
+func (a *Acceptor) OnRecvAccept(p2a *wire.PxsMsgAccept, from uint32) (int, error) {
+	log.Printf("[%d]Acceptor.OnRecvAccept - p2a:%+v,from:%d\n", a.node.id, p2a, from)
+	iid := p2a.Hdr.IID
+	bal := p2a.Bal
+	val := &p2a.Val
+	maxBal := a.maxBal[iid] //maxBal can be 0 if accept it without phase 1;
+	if maxBal <= bal {
+		//accept proposal
+		a.maxBal[iid] = bal
+		a.maxVBal[iid] = bal
+		a.maxVal[iid] = val
+		if err := a.node.logRecords(a.record(iid)); err != nil { //vote kept before told
+			return int(PxsStatusStorageFailure), err
+		}
+		//send p2b
+		p2b := wire.NewPxsMsgAccepted(iid, a.node.id, bal, val)
+		bs, _ := p2b.Encode()
+		return a.node.SendTo(from, bs)
+	}
+
+	log.Printf("[%d]NACK for p2a - maxBal:%d > bal:%d\n", a.node.id, maxBal, bal)
+	return 0, nil
+}
+
+//OnRecvCommit :
+func (a *Acceptor) OnRecvCommit(cmt *wire.PxsMsgCommit, from uint32) (int, error) {
+	log.Printf("[%d]Acceptor.OnRecvCommit - cmt:%+v,from:%d\n", a.node.id, cmt, from)
+	//TODO
+	if a.node.instanceID <= cmt.Hdr.IID { //chosen, proposers skip it.
+		a.node.instanceID = cmt.Hdr.IID + 1
+	}
+	return 0, nil
+}
+
+//Learner :
+type Learner struct {
+	node   *Node
+	chosen map[uint32]*wire.Value //iid -> chosen value
+	next   uint32                 //next iid to apply to the state machine
+	//cu : query of catchUp in flight, nil if none; cuTries: peers asked.
+	cu      Timer
+	cuTries int
+	//OnLearn : called once for every iid learned.
+	OnLearn func(iid uint32, val *wire.Value)
+}
+
+//NewLearner :
+func NewLearner(node *Node) *Learner {
+	l := new(Learner)
+	l.node = node
+	l.chosen = make(map[uint32]*wire.Value)
+	l.next = 1
+	return l
+}
+
+//Start :
+func (a *Learner) Start() error {
+	return nil
+}
+
+//OnRecvCommit : ballot bal is chosen for iid with the value sent,
+//or the one the local acceptor has for bal.
+func (l *Learner) OnRecvCommit(cmt *wire.PxsMsgCommit, from uint32) {
+	iid := cmt.Hdr.IID
+	if _, ok := l.chosen[iid]; ok {
+		return
+	}
+	if !cmt.Val.IsNone() {
+		l.learn(iid, &cmt.Val)
+		if l.next < iid { //missed some, or new to the log.
+			l.catchUp(from)
+		}
+		return
+	}
+	a := l.node.acceptor
+	if a == nil || a.maxVal[iid] == nil || a.maxVBal[iid] != cmt.Bal {
+		log.Printf("[%d]Learner missed - iid:%d, bal:%d\n", l.node.id, iid, cmt.Bal)
+		l.catchUp(from) //local acceptor missed ballot bal.
+		return
+	}
+	l.learn(iid, a.maxVal[iid])
+}
+
+//OnRecvQuery : tell a client the value chosen for iid, if any, and
+//how far the log is applied.
+func (l *Learner) OnRecvQuery(qry *wire.PxsMsgQuery, from uint32) {
+	iid := qry.Hdr.IID
+	ret := uint32(PxsStatusNotChosen)
+	val := l.chosen[iid]
+	if val != nil {
+		ret = uint32(PxsStatusOK)
+	}
+	bs, err := wire.NewPxsMsgLog(iid, qry.Seq, ret, l.next, l.node.leaderID, val).Encode()
+	if err != nil {
+		log.Printf("[%d]Learner.OnRecvQuery - iid:%d, err:%s\n", l.node.id, iid, err)
+		return
+	}
+	l.node.SendTo(from, bs)
+}
+
+//learn : val is chosen for iid; apply what is chosen in iid order.
+func (l *Learner) learn(iid uint32, val *wire.Value) {
+	if _, ok := l.chosen[iid]; ok {
+		return
+	}
+	l.chosen[iid] = val
+	l.node.logChosen(iid, val)
+	if l.OnLearn != nil {
+		l.OnLearn(iid, val)
+	}
+	l.apply()
+	if l.node.proposer != nil {
+		l.node.proposer.onLearned(iid, val)
+	}
+}
+
+//apply : values chosen from next on, up to the 1st hole.
+func (l *Learner) apply() {
+	for v, ok := l.chosen[l.next]; ok; v, ok = l.chosen[l.next] {
+		var cli, seq uint32
+		var res *wire.Value
+		ret := PxsStatusOK
+		if op, err := DecodeMemberOp(v); err == nil {
+			var out string
+			ret, out = l.node.applyMemberOp(l.next, op)
+			cli, seq, res = op.Cli, op.Seq, wire.NewValue([]byte(out))
+		} else {
+			cli, seq, res = l.node.rsm.Apply(l.next, v)
+		}
+		l.next++
+		if cli != 0 && l.node.proposer != nil {
+			l.node.proposer.onApplied(cli, seq, ret, res)
+		}
+	}
+}
+
+//nextHole : 1st iid not learned yet.
+func (l *Learner) nextHole() uint32 {
+	iid := l.next
+	for l.chosen[iid] != nil {
+		iid++
+	}
+	return iid
+}
@@ -0,0 +1,311 @@
+package paxos
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/wilem/simple-paxos/config"
+	"github.com/wilem/simple-paxos/wire"
+)
+
+// SafetyChecker : watches the msgs and learners of a test cluster and
+// asserts the Paxos invariants:
+//   - at most one value is chosen per iid, and it never changes: once
+//     chosen, higher ballots are only accepted with the same value;
+//   - no acceptor accepts a ballot below one it promised;
+//   - learners only learn the chosen value.
+//
+// A value is chosen for iid by a quorum of the acceptors of iid, which
+// the member ops chosen before change as they do on the nodes; until
+// the members of iid are known its votes wait.
+// Violations come with a trace of the msgs of their instance.
+type SafetyChecker struct {
+	members  *membership                             //as changed by the member ops chosen
+	applied  uint32                                  //member ops chosen up to applied are in members
+	waiting  map[uint32]bool                         //iid with votes or learns, its members unknown
+	promised map[[2]uint32]uint32                    //[iid,acc] -> highest ballot promised or accepted
+	votes    map[uint32]map[uint32]map[uint32][]byte //iid -> bal -> acc -> value accepted
+	chosen   map[uint32]safetyChoice                 //iid -> value chosen, at its lowest ballot
+	learned  map[uint32]map[uint32][]byte            //iid -> node -> value learned
+	trace    map[uint32][]string                     //iid -> msgs seen
+	hooked   map[*Learner]bool                       //learners watched
+	errs     []error
+}
+
+//safetyChoice : value chosen by a quorum at ballot bal.
+type safetyChoice struct {
+	bal uint32
+	val []byte
+}
+
+//ErrSafety : a Paxos invariant is broken.
+var ErrSafety = errors.New("safety violated")
+
+//NewSafetyChecker : for a cluster whose members are those of cfg at
+//first.
+func NewSafetyChecker(cfg *config.ClusterConfig) *SafetyChecker {
+	c := new(SafetyChecker)
+	c.members = newMembership(cfg)
+	c.waiting = make(map[uint32]bool)
+	c.promised = make(map[[2]uint32]uint32)
+	c.votes = make(map[uint32]map[uint32]map[uint32][]byte)
+	c.chosen = make(map[uint32]safetyChoice)
+	c.learned = make(map[uint32]map[uint32][]byte)
+	c.trace = make(map[uint32][]string)
+	c.hooked = make(map[*Learner]bool)
+	return c
+}
+
+//Watch : observe every msg sent on s and every learner of its nodes,
+//those which take the role later included.
+func (c *SafetyChecker) Watch(s *Sim) {
+	s.Tap = func(at time.Duration, src, dst uint32, data []byte) {
+		c.hook(s)
+		c.Observe(at, src, dst, data)
+	}
+	c.hook(s)
+}
+
+//hook : watch the learners of s not watched yet.
+func (c *SafetyChecker) hook(s *Sim) {
+	for id, n := range s.nodeMap {
+		if n.learner != nil && !c.hooked[n.learner] {
+			id := id
+			c.hooked[n.learner] = true
+			n.learner.OnLearn = func(iid uint32, val *wire.Value) {
+				c.Learn(s.Elapsed(), id, iid, val.Oct)
+			}
+		}
+	}
+}
+
+//Observe : msgs in data sent at time at from src to dst.
+func (c *SafetyChecker) Observe(at time.Duration, src, dst uint32, data []byte) {
+	var buf bytes.Buffer
+	for bs := data; ; bs = nil {
+		msg, _, _, err := wire.DecodeOnePxsMsg(&buf, bs)
+		if errors.Is(err, wire.ErrPxsMsgIncomplete) || errors.Is(err, wire.ErrPxsMsgMagic) ||
+			errors.Is(err, wire.ErrPxsMsgTooLarge) {
+			return
+		}
+		if err != nil {
+			continue
+		}
+		switch m := msg.(type) {
+		case *wire.PxsMsgPrepare:
+			c.log(m.Hdr.IID, at, "%d->%d 1a bal:%s", src, dst, fmtBallot(m.Bal))
+		case *wire.PxsMsgPromise:
+			c.log(m.Hdr.IID, at, "%d->%d 1b bal:%s vbal:%s val:%v", src, dst, fmtBallot(m.Bal), fmtBallot(m.MVBal), m.MVal.Oct)
+			c.onPromise(m)
+		case *wire.PxsMsgAccept:
+			c.log(m.Hdr.IID, at, "%d->%d 2a bal:%s val:%v", src, dst, fmtBallot(m.Bal), m.Val.Oct)
+		case *wire.PxsMsgAccepted:
+			c.log(m.Hdr.IID, at, "%d->%d 2b bal:%s val:%v", src, dst, fmtBallot(m.Bal), m.Val.Oct)
+			c.onAccepted(m)
+		case *wire.PxsMsgCommit:
+			c.log(m.Hdr.IID, at, "%d->%d 3a bal:%s", src, dst, fmtBallot(m.Bal))
+		}
+	}
+}
+
+//Learn : node learned val for iid at time at.
+func (c *SafetyChecker) Learn(at time.Duration, node, iid uint32, val []byte) {
+	c.log(iid, at, "%d learned val:%v", node, val)
+	if c.learned[iid] == nil {
+		c.learned[iid] = make(map[uint32][]byte)
+	}
+	if old, ok := c.learned[iid][node]; ok && !bytes.Equal(old, val) {
+		c.fail(iid, "node %d learned %v, then %v", node, old, val)
+	}
+	c.learned[iid][node] = val
+	if !c.known(iid) {
+		c.waiting[iid] = true
+		return
+	}
+	c.checkLearned(iid, node)
+}
+
+//checkLearned : of node for iid, against the value chosen.
+func (c *SafetyChecker) checkLearned(iid, node uint32) {
+	val := c.learned[iid][node]
+	ch, ok := c.chosen[iid]
+	switch {
+	case !ok:
+		c.fail(iid, "node %d learned %v, nothing chosen", node, val)
+	case !bytes.Equal(ch.val, val):
+		c.fail(iid, "node %d learned %v, chosen %v", node, val, ch.val)
+	}
+}
+
+//Err : 1st violation with its trace, nil if none.
+func (c *SafetyChecker) Err() error {
+	if len(c.errs) == 0 {
+		return nil
+	}
+	return c.errs[0]
+}
+
+//Chosen : num of iids chosen.
+func (c *SafetyChecker) Chosen() int {
+	return len(c.chosen)
+}
+
+func (c *SafetyChecker) onPromise(m *wire.PxsMsgPromise) {
+	key := [2]uint32{m.Hdr.IID, m.Acc}
+	if prm := c.promised[key]; m.Bal <= prm {
+		c.fail(m.Hdr.IID, "acceptor %d promised %s after %s", m.Acc, fmtBallot(m.Bal), fmtBallot(prm))
+	}
+	c.promised[key] = m.Bal
+}
+
+func (c *SafetyChecker) onAccepted(m *wire.PxsMsgAccepted) {
+	iid := m.Hdr.IID
+	key := [2]uint32{iid, m.Acc}
+	if prm := c.promised[key]; m.Bal < prm {
+		c.fail(iid, "acceptor %d accepted %s below promised %s", m.Acc, fmtBallot(m.Bal), fmtBallot(prm))
+	} else {
+		c.promised[key] = m.Bal
+	}
+	if ch, ok := c.chosen[iid]; ok && m.Bal > ch.bal && !bytes.Equal(m.Val.Oct, ch.val) {
+		c.fail(iid, "acceptor %d accepted %v at %s, %v chosen at %s",
+			m.Acc, m.Val.Oct, fmtBallot(m.Bal), ch.val, fmtBallot(ch.bal))
+	}
+	if c.votes[iid] == nil {
+		c.votes[iid] = make(map[uint32]map[uint32][]byte)
+	}
+	if c.votes[iid][m.Bal] == nil {
+		c.votes[iid][m.Bal] = make(map[uint32][]byte)
+	}
+	c.votes[iid][m.Bal][m.Acc] = m.Val.Oct
+	if !c.known(iid) {
+		c.waiting[iid] = true
+		return
+	}
+	c.count(iid, m.Bal)
+	c.advance()
+}
+
+//known : the members of iid are, all member ops which change them are
+//applied.
+func (c *SafetyChecker) known(iid uint32) bool {
+	return c.members.alpha == 0 || iid <= c.applied+c.members.alpha
+}
+
+//count : votes of the acceptors of iid at bal; a value a quorum of them
+//accepted is chosen.
+func (c *SafetyChecker) count(iid, bal uint32) {
+	ms := c.members.at(iid)
+	votes := c.votes[iid][bal]
+	for _, acc := range ms.Acceptors {
+		val, ok := votes[acc]
+		if !ok {
+			continue
+		}
+		var n uint32
+		for _, id := range ms.Acceptors {
+			if v, ok := votes[id]; ok && bytes.Equal(v, val) {
+				n++
+			}
+		}
+		if n >= ms.quorum() {
+			c.choose(iid, bal, val)
+			return
+		}
+	}
+}
+
+//choose : val is chosen for iid at bal; no other value may be, nor
+//accepted at a higher ballot.
+func (c *SafetyChecker) choose(iid, bal uint32, val []byte) {
+	ch, ok := c.chosen[iid]
+	if ok {
+		switch {
+		case !bytes.Equal(ch.val, val):
+			c.fail(iid, "two values chosen: %v at %s, %v at %s",
+				ch.val, fmtBallot(ch.bal), val, fmtBallot(bal))
+		case bal < ch.bal:
+			c.chosen[iid] = safetyChoice{bal, val}
+		}
+		return
+	}
+	c.chosen[iid] = safetyChoice{bal, val}
+	for _, b := range c.ballots(iid) { //votes counted late
+		for _, acc := range sortedIDs(c.votes[iid][b]) {
+			if v := c.votes[iid][b][acc]; b > bal && !bytes.Equal(v, val) {
+				c.fail(iid, "acceptor %d accepted %v at %s, %v chosen at %s",
+					acc, v, fmtBallot(b), val, fmtBallot(bal))
+			}
+		}
+	}
+}
+
+//advance : apply the member ops chosen, in iid order; the votes of an
+//iid whose members get known with them are counted.
+func (c *SafetyChecker) advance() {
+	for {
+		ch, ok := c.chosen[c.applied+1]
+		if !ok {
+			return
+		}
+		c.applied++
+		if op, err := DecodeMemberOp(wire.NewValue(ch.val)); err == nil {
+			c.members.apply(c.applied, op)
+		}
+		iid := c.applied + c.members.alpha
+		if !c.waiting[iid] {
+			continue
+		}
+		delete(c.waiting, iid)
+		for _, bal := range c.ballots(iid) {
+			c.count(iid, bal)
+		}
+		for _, node := range sortedIDs(c.learned[iid]) {
+			c.checkLearned(iid, node)
+		}
+	}
+}
+
+//ballots : with votes for iid, in order.
+func (c *SafetyChecker) ballots(iid uint32) []uint32 {
+	bals := make([]uint32, 0, len(c.votes[iid]))
+	for bal := range c.votes[iid] {
+		bals = append(bals, bal)
+	}
+	sort.Slice(bals, func(i, j int) bool { return bals[i] < bals[j] })
+	return bals
+}
+
+//sortedIDs : node IDs of m, in order.
+func sortedIDs(m map[uint32][]byte) []uint32 {
+	ids := make([]uint32, 0, len(m))
+	for id := range m {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids
+}
+
+func (c *SafetyChecker) log(iid uint32, at time.Duration, format string, args ...interface{}) {
+	c.trace[iid] = append(c.trace[iid], fmt.Sprintf("%12s ", at)+fmt.Sprintf(format, args...))
+}
+
+func (c *SafetyChecker) fail(iid uint32, format string, args ...interface{}) {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "iid %d: "+format+"\ntrace of iid %d:\n", append([]interface{}{iid}, append(args, iid)...)...)
+	for _, ln := range c.trace[iid] {
+		sb.WriteString("    " + ln + "\n")
+	}
+	c.errs = append(c.errs, fmt.Errorf("%w: %s", ErrSafety, sb.String()))
+}
+
+//fmtBallot : round.proposer of a ballot, see getNextBallot.
+func fmtBallot(bal uint32) string {
+	if bal == wire.Invalidballot {
+		return "-"
+	}
+	return fmt.Sprintf("%d.%d", bal>>16, bal&0xFFFF)
+}
@@ -0,0 +1,124 @@
+package paxos
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/wilem/simple-paxos/wire"
+)
+
+//checkerFeed : msgs seen by c, sent by their acceptor to proposer 1.
+func checkerFeed(c *SafetyChecker, msgs ...interface{ Encode() ([]byte, error) }) {
+	for _, m := range msgs {
+		bs, _ := m.Encode()
+		var acc uint32
+		switch m := m.(type) {
+		case *wire.PxsMsgPromise:
+			acc = m.Acc
+		case *wire.PxsMsgAccepted:
+			acc = m.Acc
+		}
+		c.Observe(0, acc, 1, bs)
+	}
+}
+
+func TestSafetyChecker(t *testing.T) {
+	a, b := wire.NewValue([]byte("a")), wire.NewValue([]byte("b"))
+	const b11, b12, b21 = 1<<16 | 1, 1<<16 | 2, 2<<16 | 1
+
+	//a chosen at 1.1, then b accepted at 2.1 and chosen too.
+	c := NewSafetyChecker(simConfig(1, 3))
+	checkerFeed(c, wire.NewPxsMsgAccepted(7, 1, b11, a), wire.NewPxsMsgAccepted(7, 2, b11, a))
+	c.Learn(0, 3, 7, a.Oct)
+	if c.Err() != nil || c.Chosen() != 1 {
+		t.Fatal("clean run:", c.Err())
+	}
+	checkerFeed(c, wire.NewPxsMsgAccepted(7, 3, b21, b))
+	err := c.Err()
+	if !errors.Is(err, ErrSafety) || !strings.Contains(err.Error(), "iid 7: acceptor 3 accepted [98] at 2.1") {
+		t.Fatal("changed value not caught:", err)
+	}
+	//trace lists the msgs of iid 7
+	if strings.Count(err.Error(), " 2b bal:") != 3 || !strings.Contains(err.Error(), "3 learned val:[97]") {
+		t.Error("trace:", err)
+	}
+	checkerFeed(c, wire.NewPxsMsgAccepted(7, 2, b21, b))
+	if len(c.errs) != 3 || !strings.Contains(c.errs[2].Error(), "two values chosen: [97] at 1.1, [98] at 2.1") {
+		t.Error("2nd value chosen not caught:", c.errs)
+	}
+
+	//accepted below promise.
+	c = NewSafetyChecker(simConfig(1, 3))
+	checkerFeed(c, wire.NewPxsMsgPromise(1, 1, b12, wire.Invalidballot, &wire.Value{}), wire.NewPxsMsgAccepted(1, 1, b11, a))
+	if err := c.Err(); err == nil || !strings.Contains(err.Error(), "accepted 1.1 below promised 1.2") {
+		t.Error("accept below promise not caught:", err)
+	}
+
+	//promise going back.
+	c = NewSafetyChecker(simConfig(1, 3))
+	checkerFeed(c, wire.NewPxsMsgPromise(1, 1, b12, wire.Invalidballot, &wire.Value{}), wire.NewPxsMsgPromise(1, 1, b11, wire.Invalidballot, &wire.Value{}))
+	if c.Err() == nil {
+		t.Error("promise below promise not caught")
+	}
+
+	//learner ahead of, or off, the chosen value.
+	c = NewSafetyChecker(simConfig(1, 3))
+	c.Learn(0, 1, 1, a.Oct)
+	checkerFeed(c, wire.NewPxsMsgAccepted(1, 1, b11, b), wire.NewPxsMsgAccepted(1, 2, b11, b))
+	c.Learn(0, 2, 1, a.Oct)
+	if len(c.errs) != 2 {
+		t.Error("bad learns not caught:", c.errs)
+	}
+}
+
+func TestSafetyCheckerMembers(t *testing.T) {
+	a, b := wire.NewValue([]byte("a")), wire.NewValue([]byte("b"))
+	const b11 = 1<<16 | 1
+	cfg := simConfig(1, 3)
+	cfg.ServerList = seqIDs(5)
+	cfg.Alpha = 2
+	c := NewSafetyChecker(cfg)
+	add4 := (&MemberOp{Cli: 9, Seq: 1, Op: MemberOpAdd, Role: RoleAcceptor, ID: 4}).Value()
+
+	//iid 3 waits for its members, acceptors 1..4 from the op of iid 1.
+	checkerFeed(c, wire.NewPxsMsgAccepted(3, 1, b11, a), wire.NewPxsMsgAccepted(3, 2, b11, a))
+	checkerFeed(c, wire.NewPxsMsgAccepted(1, 1, b11, add4), wire.NewPxsMsgAccepted(1, 2, b11, add4))
+	if c.Chosen() != 1 {
+		t.Fatal("2 of 4 acceptors chose iid 3:", c.Chosen())
+	}
+	checkerFeed(c, wire.NewPxsMsgAccepted(3, 4, b11, a))
+	if c.Chosen() != 2 || c.Err() != nil {
+		t.Fatal("3 of 4 acceptors did not choose iid 3:", c.Chosen(), c.Err())
+	}
+	//node 5 is no acceptor; node 3 learns iid 4 before its members are known.
+	checkerFeed(c, wire.NewPxsMsgAccepted(4, 3, b11, b), wire.NewPxsMsgAccepted(4, 4, b11, b), wire.NewPxsMsgAccepted(4, 5, b11, b))
+	c.Learn(0, 3, 4, b.Oct)
+	if c.Err() != nil {
+		t.Fatal("iid 4 judged with unknown members:", c.Err())
+	}
+	checkerFeed(c, wire.NewPxsMsgAccepted(2, 1, b11, a), wire.NewPxsMsgAccepted(2, 2, b11, a))
+	if c.Chosen() != 3 || len(c.errs) != 1 || !strings.Contains(c.Err().Error(), "iid 4: node 3 learned [98], nothing chosen") {
+		t.Error("vote of node 5 counted:", c.Chosen(), c.errs)
+	}
+}
+
+func TestSafetyCheckerSim(t *testing.T) {
+	s := NewSim(3, SimFaults{MaxLatency: 5e6})
+	newSimCluster(s, 3)
+	c := NewSafetyChecker(simConfig(1, 3))
+	c.Watch(s)
+	for seq := uint32(1); seq <= 3; seq++ {
+		bs, _ := wire.NewPxsMsgRequest(seq, wire.NewValue([]byte{byte(seq)})).Encode()
+		s.Node(9).SendTo(seq, bs)
+	}
+	s.RunFor(5e9)
+	if c.Err() != nil || c.Chosen() != 3 {
+		t.Error("chosen:", c.Chosen(), c.Err())
+	}
+	for id := uint32(1); id <= 3; id++ {
+		if len(s.Node(id).learner.chosen) != 3 {
+			t.Error("learner", id, "chosen:", s.Node(id).learner.chosen)
+		}
+	}
+}
@@ -0,0 +1,29 @@
+package paxos
+
+import (
+	"time"
+)
+
+//Timer : pending call of a Clock.
+type Timer interface {
+	//Stop : cancel the call, false if it already ran or was stopped.
+	Stop() bool
+}
+
+//Clock : time source of a node; wall clock, or virtual in a Sim.
+type Clock interface {
+	Now() time.Time
+	//AfterFunc : call f once, d from now.
+	AfterFunc(d time.Duration, f func()) Timer
+}
+
+//realClock : wall clock, timers fire in their own goroutine.
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (realClock) AfterFunc(d time.Duration, f func()) Timer {
+	return time.AfterFunc(d, f)
+}
@@ -0,0 +1,123 @@
+package paxos
+
+import (
+	"sync"
+
+	"github.com/wilem/simple-paxos/transport"
+)
+
+// eventLoop : runs the events of a node one at a time, in the order
+// posted: msgs received, timers and API calls. Only events touch the
+// state of the node, so it needs no other lock.
+//
+// Once started, events run in the goroutine of the loop. Until then, or
+// for harnesses which step nodes themselves (Sim, MemFabric), they run
+// inline: whoever posts to an idle loop runs events until none is left,
+// events posted meanwhile are queued for it.
+type eventLoop struct {
+	mu      sync.Mutex
+	queue   []func()
+	busy    bool          //an event is being run.
+	running bool          //loop goroutine started.
+	wake    chan struct{} //events posted to a running loop.
+	quit    chan struct{}
+	wg      sync.WaitGroup
+}
+
+//post : run f after the events posted so far; doesn't wait for it.
+func (l *eventLoop) post(f func()) {
+	l.mu.Lock()
+	l.queue = append(l.queue, f)
+	running, wake := l.running, l.wake
+	l.mu.Unlock()
+	if !running {
+		l.drain()
+		return
+	}
+	select {
+	case wake <- struct{}{}:
+	default: //woken already
+	}
+}
+
+//exec : post f and wait until it ran. Not to be called from an event,
+//it would wait for itself.
+func (l *eventLoop) exec(f func()) {
+	done := make(chan struct{})
+	l.post(func() {
+		f()
+		close(done)
+	})
+	<-done
+}
+
+//drain : run events until none is left, unless someone else does.
+func (l *eventLoop) drain() {
+	l.mu.Lock()
+	if l.busy {
+		l.mu.Unlock()
+		return
+	}
+	l.busy = true
+	for len(l.queue) > 0 {
+		f := l.queue[0]
+		l.queue[0] = nil
+		l.queue = l.queue[1:]
+		l.mu.Unlock()
+		f()
+		l.mu.Lock()
+	}
+	l.busy = false
+	l.mu.Unlock()
+}
+
+//start : run events in a goroutine of their own.
+func (l *eventLoop) start() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.running {
+		return
+	}
+	l.running = true
+	l.wake = make(chan struct{}, 1)
+	l.quit = make(chan struct{})
+	l.wg.Add(1)
+	go l.run(l.wake, l.quit)
+}
+
+func (l *eventLoop) run(wake, quit chan struct{}) {
+	defer l.wg.Done()
+	for {
+		select {
+		case <-wake:
+			l.drain()
+		case <-quit:
+			l.drain()
+			return
+		}
+	}
+}
+
+//stop : run the events posted so far, then end the goroutine; events
+//run inline again from now on.
+func (l *eventLoop) stop() {
+	l.mu.Lock()
+	if !l.running {
+		l.mu.Unlock()
+		return
+	}
+	close(l.quit)
+	l.mu.Unlock()
+	l.wg.Wait()
+	l.mu.Lock()
+	l.running = false
+	l.mu.Unlock()
+	l.drain() //posted while stopping
+}
+
+//inlineTransport : transport of a harness which delivers msgs and fires
+//timers itself; its nodes run events inline, no loop goroutine.
+type inlineTransport interface {
+	transport.ITransport
+	InlineEvents()
+}
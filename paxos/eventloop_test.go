@@ -0,0 +1,50 @@
+package paxos
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+)
+
+func TestEventLoop(t *testing.T) {
+	var l eventLoop
+	var got []int
+	//inline: events posted by an event run after it, in order.
+	l.post(func() {
+		l.post(func() { got = append(got, 2) })
+		l.post(func() { got = append(got, 3) })
+		got = append(got, 1)
+	})
+	if fmt.Sprint(got) != "[1 2 3]" {
+		t.Fatal("inline order:", got)
+	}
+	//loop: events of many goroutines, one at a time.
+	l.start()
+	var wg sync.WaitGroup
+	var n int
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for k := 0; k < 100; k++ {
+				l.post(func() { n++ })
+			}
+		}()
+	}
+	wg.Wait()
+	l.exec(func() {
+		if n != 800 {
+			t.Error("events run before exec:", n)
+		}
+	})
+	//stop runs what was posted, then events run inline again.
+	l.post(func() { n++ })
+	l.stop()
+	if n != 801 {
+		t.Error("events run by stop:", n)
+	}
+	l.exec(func() { n++ })
+	if n != 802 {
+		t.Error("inline exec after stop:", n)
+	}
+}
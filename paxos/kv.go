@@ -0,0 +1,133 @@
+package paxos
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"github.com/wilem/simple-paxos/wire"
+)
+
+//KVOpType : operation of the KV state machine.
+type KVOpType uint8
+
+const (
+	//KVOpGet : read Key.
+	KVOpGet KVOpType = 1
+	//KVOpPut : set Key to Val.
+	KVOpPut KVOpType = 2
+)
+
+//ValueType : kind of a log value, its 1st byte. A value decodes only
+//as the kind it is tagged with, whatever the data of the call.
+type ValueType uint8
+
+const (
+	//ValueKVOp : a KVOp.
+	ValueKVOp ValueType = 1
+	//ValueMemberOp : a MemberOp.
+	ValueMemberOp ValueType = 2
+)
+
+//kvOpFixedSize : type,cli,seq,op,key len
+const kvOpFixedSize = 1 + 4 + 4 + 1 + 2
+
+//ErrKVOp : value is not a KV op.
+var ErrKVOp = errors.New("kv: bad op")
+
+//KVOp : client call of the KV state machine, carried in a Value.
+//Cli and Seq identify the call, so a retried call is applied once.
+type KVOp struct {
+	Cli, Seq uint32
+	Op       KVOpType
+	Key, Val string
+}
+
+//Value : op encoded as ValueKVOp,cli,seq,op,len(key),key,val
+func (o *KVOp) Value() *wire.Value {
+	bs := make([]byte, kvOpFixedSize, kvOpFixedSize+len(o.Key)+len(o.Val))
+	bs[0] = byte(ValueKVOp)
+	binary.LittleEndian.PutUint32(bs[1:], o.Cli)
+	binary.LittleEndian.PutUint32(bs[5:], o.Seq)
+	bs[9] = byte(o.Op)
+	binary.LittleEndian.PutUint16(bs[10:], uint16(len(o.Key)))
+	bs = append(append(bs, o.Key...), o.Val...)
+	return wire.NewValue(bs)
+}
+
+//DecodeKVOp : op carried in val.
+func DecodeKVOp(val *wire.Value) (*KVOp, error) {
+	bs := val.Oct
+	if len(bs) < kvOpFixedSize || ValueType(bs[0]) != ValueKVOp {
+		return nil, fmt.Errorf("%w: %d bytes", ErrKVOp, len(bs))
+	}
+	o := new(KVOp)
+	o.Cli = binary.LittleEndian.Uint32(bs[1:])
+	o.Seq = binary.LittleEndian.Uint32(bs[5:])
+	o.Op = KVOpType(bs[9])
+	klen := int(binary.LittleEndian.Uint16(bs[10:]))
+	if o.Cli == 0 || (o.Op != KVOpGet && o.Op != KVOpPut) || kvOpFixedSize+klen > len(bs) {
+		return nil, fmt.Errorf("%w: cli:%d, op:%d, key len:%d", ErrKVOp, o.Cli, o.Op, klen)
+	}
+	o.Key = string(bs[kvOpFixedSize : kvOpFixedSize+klen])
+	o.Val = string(bs[kvOpFixedSize+klen:])
+	return o, nil
+}
+
+//StateMachine : replicated by learners, chosen values are applied in iid order.
+type StateMachine interface {
+	//Apply : execute val chosen for iid; returns the client call it
+	//completes and its result, cli is 0 if none.
+	Apply(iid uint32, val *wire.Value) (cli, seq uint32, res *wire.Value)
+}
+
+//kvResult : last call of a client applied.
+type kvResult struct {
+	seq uint32
+	res *wire.Value
+}
+
+//KVStore : key-value StateMachine. Each client has one call in flight;
+//a call chosen twice is applied once, both get the result of the 1st.
+type KVStore struct {
+	data map[string]string
+	last map[uint32]kvResult //client -> last call applied
+}
+
+//NewKVStore :
+func NewKVStore() *KVStore {
+	s := new(KVStore)
+	s.data = make(map[string]string)
+	s.last = make(map[uint32]kvResult)
+	return s
+}
+
+//Apply : values which are not KV ops are skipped.
+func (s *KVStore) Apply(iid uint32, val *wire.Value) (cli, seq uint32, res *wire.Value) {
+	op, err := DecodeKVOp(val)
+	if err != nil {
+		return 0, 0, nil
+	}
+	if l, ok := s.last[op.Cli]; ok && op.Seq <= l.seq {
+		if op.Seq == l.seq { //retried
+			return op.Cli, op.Seq, l.res
+		}
+		return 0, 0, nil //client moved on already
+	}
+	var out string
+	switch op.Op {
+	case KVOpGet:
+		out = s.data[op.Key]
+	case KVOpPut:
+		s.data[op.Key] = op.Val
+	}
+	res = wire.NewValue([]byte(out))
+	s.last[op.Cli] = kvResult{op.Seq, res}
+	return op.Cli, op.Seq, res
+}
+
+//Get : value of key, as applied so far.
+func (s *KVStore) Get(key string) (string, bool) {
+	v, ok := s.data[key]
+	return v, ok
+}
@@ -0,0 +1,62 @@
+package paxos
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/wilem/simple-paxos/wire"
+)
+
+func TestKVOp(t *testing.T) {
+	op := &KVOp{Cli: 9, Seq: 3, Op: KVOpPut, Key: "k", Val: "v1"}
+	got, err := DecodeKVOp(op.Value())
+	if err != nil || *got != *op {
+		t.Error("round trip:", got, err)
+	}
+	for _, bad := range []*wire.Value{
+		wire.NewValue([]byte("xx")),
+		(&KVOp{Cli: 0, Seq: 1, Op: KVOpGet}).Value(),
+		(&KVOp{Cli: 1, Seq: 1, Op: 7}).Value(),
+		wire.NewValue(append((&KVOp{Cli: 1, Seq: 1, Op: KVOpGet, Key: "kk"}).Value().Oct[:12], 'k')),
+		wire.NewValue(append([]byte{byte(ValueMemberOp)}, op.Value().Oct[1:]...)),
+	} {
+		if _, err := DecodeKVOp(bad); !errors.Is(err, ErrKVOp) {
+			t.Error("bad op decoded:", bad.Oct, err)
+		}
+	}
+}
+
+func TestKVStore(t *testing.T) {
+	s := NewKVStore()
+	apply := func(iid uint32, op *KVOp) (uint32, string) {
+		cli, seq, res := s.Apply(iid, op.Value())
+		if cli != 0 && cli != op.Cli {
+			t.Error("cli:", cli)
+		}
+		if res == nil {
+			return seq, "<nil>"
+		}
+		return seq, string(res.Oct)
+	}
+	if _, res := apply(1, &KVOp{Cli: 9, Seq: 1, Op: KVOpPut, Key: "k", Val: "a"}); res != "" {
+		t.Error("put:", res)
+	}
+	if seq, res := apply(2, &KVOp{Cli: 9, Seq: 2, Op: KVOpGet, Key: "k"}); seq != 2 || res != "a" {
+		t.Error("get:", seq, res)
+	}
+	//chosen twice: applied once, same result.
+	apply(3, &KVOp{Cli: 8, Seq: 1, Op: KVOpPut, Key: "k", Val: "b"})
+	if seq, res := apply(4, &KVOp{Cli: 9, Seq: 2, Op: KVOpGet, Key: "k"}); seq != 2 || res != "a" {
+		t.Error("retried get:", seq, res)
+	}
+	if _, res := apply(5, &KVOp{Cli: 9, Seq: 1, Op: KVOpPut, Key: "k", Val: "a"}); res != "<nil>" {
+		t.Error("old call applied:", res)
+	}
+	if v, _ := s.Get("k"); v != "b" {
+		t.Error("k:", v)
+	}
+	//other values are skipped
+	if cli, _, _ := s.Apply(6, wire.NewValue([]byte{1, 2})); cli != 0 {
+		t.Error("foreign value applied")
+	}
+}
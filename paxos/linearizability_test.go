@@ -0,0 +1,422 @@
+package paxos
+
+import (
+	"fmt"
+	"hash/fnv"
+	"io"
+	"log"
+	"math"
+	"os"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+//linOp : one client call of a history on the KV state machine.
+type linOp struct {
+	cli       uint32
+	op        KVOpType
+	key       string
+	val       string        //put input
+	out       string        //get output
+	call, ret time.Duration //ret < 0: never completed
+}
+
+func (o *linOp) String() string {
+	if o.op == KVOpPut {
+		return fmt.Sprintf("put %s=%s", o.key, o.val)
+	}
+	if o.ret < 0 {
+		return fmt.Sprintf("get %s:?", o.key)
+	}
+	return fmt.Sprintf("get %s:%s", o.key, o.out)
+}
+
+//linHistory : invoke and complete events of client calls.
+type linHistory struct {
+	ops []*linOp
+}
+
+//invoke : op called by cli at time at.
+func (h *linHistory) invoke(at time.Duration, cli uint32, op *KVOp) *linOp {
+	o := &linOp{cli: cli, op: op.Op, key: op.Key, val: op.Val, call: at, ret: -1}
+	h.ops = append(h.ops, o)
+	return o
+}
+
+//complete : o returned out at time at.
+func (o *linOp) complete(at time.Duration, out string) {
+	o.ret, o.out = at, out
+}
+
+//linResult : outcome of checking the ops of one key.
+type linResult struct {
+	key  string
+	ops  []*linOp
+	ok   bool
+	best []int //longest linearization found, indexes into ops
+}
+
+//checkLinearizable : Porcupine-style check of a KV history: the ops are
+//split by key, each key is a register starting as "". For every key a
+//linearization is searched depth first in call order (Wing & Gong),
+//skipping (ops linearized, state) pairs already tried (Lowe). Calls
+//never completed may have taken effect at any time after their call;
+//gets among them are dropped, having no output. Returns failed keys.
+func checkLinearizable(ops []*linOp) (bad []linResult) {
+	byKey := make(map[string][]*linOp)
+	var keys []string
+	for _, o := range ops {
+		if o.ret < 0 && o.op == KVOpGet {
+			continue
+		}
+		if _, ok := byKey[o.key]; !ok {
+			keys = append(keys, o.key)
+		}
+		byKey[o.key] = append(byKey[o.key], o)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		if r := checkLinKey(k, byKey[k]); !r.ok {
+			bad = append(bad, r)
+		}
+	}
+	return
+}
+
+//linEntry : call or return of ops[id], in a list sorted by time.
+type linEntry struct {
+	id         int
+	call       bool
+	at         time.Duration
+	match      *linEntry //return of a call
+	prev, next *linEntry
+}
+
+type linBits []uint64
+
+func (b linBits) set(i int)   { b[i/64] |= 1 << uint(i%64) }
+func (b linBits) clear(i int) { b[i/64] &^= 1 << uint(i%64) }
+func (b linBits) clone() linBits {
+	return append(linBits(nil), b...)
+}
+func (b linBits) equal(c linBits) bool {
+	for i := range b {
+		if b[i] != c[i] {
+			return false
+		}
+	}
+	return true
+}
+
+//linCache : (ops linearized, state) pairs already searched.
+type linCache map[uint64][]linCacheEntry
+
+type linCacheEntry struct {
+	done  linBits
+	state string
+}
+
+//add : false if the pair was there.
+func (c linCache) add(done linBits, state string) bool {
+	h := fnv.New64a()
+	for _, w := range done {
+		fmt.Fprintf(h, "%x.", w)
+	}
+	io.WriteString(h, state)
+	key := h.Sum64()
+	for _, e := range c[key] {
+		if e.state == state && e.done.equal(done) {
+			return false
+		}
+	}
+	c[key] = append(c[key], linCacheEntry{done, state})
+	return true
+}
+
+//linStep : o applied on register state.
+func linStep(state string, o *linOp) (string, bool) {
+	if o.op == KVOpPut {
+		return o.val, true
+	}
+	return state, o.out == state
+}
+
+func checkLinKey(key string, ops []*linOp) linResult {
+	r := linResult{key: key, ops: ops}
+	var ents []*linEntry
+	for i, o := range ops {
+		ret := o.ret
+		if ret < 0 {
+			ret = math.MaxInt64
+		}
+		c := &linEntry{id: i, call: true, at: o.call}
+		c.match = &linEntry{id: i, at: ret}
+		ents = append(ents, c, c.match)
+	}
+	//calls before returns at the same time: taken as concurrent.
+	sort.SliceStable(ents, func(i, j int) bool {
+		if ents[i].at != ents[j].at {
+			return ents[i].at < ents[j].at
+		}
+		return ents[i].call && !ents[j].call
+	})
+	head := new(linEntry)
+	prev := head
+	for _, e := range ents {
+		prev.next, e.prev = e, prev
+		prev = e
+	}
+	lift := func(e *linEntry) {
+		e.prev.next, e.next.prev = e.next, e.prev
+		m := e.match
+		m.prev.next = m.next
+		if m.next != nil {
+			m.next.prev = m.prev
+		}
+	}
+	unlift := func(e *linEntry) {
+		m := e.match
+		m.prev.next = m
+		if m.next != nil {
+			m.next.prev = m
+		}
+		e.prev.next, e.next.prev = e, e
+	}
+
+	type frame struct {
+		e     *linEntry
+		state string
+	}
+	var calls []frame
+	state := ""
+	done := make(linBits, (len(ops)+63)/64)
+	cache := make(linCache)
+	for e := head.next; head.next != nil; {
+		if e.call {
+			if next, ok := linStep(state, ops[e.id]); ok {
+				nd := done.clone()
+				nd.set(e.id)
+				if cache.add(nd, next) {
+					calls = append(calls, frame{e, state})
+					state, done = next, nd
+					lift(e)
+					if len(calls) > len(r.best) {
+						r.best = r.best[:0]
+						for _, f := range calls {
+							r.best = append(r.best, f.e.id)
+						}
+					}
+					e = head.next
+					continue
+				}
+			}
+			e = e.next
+			continue
+		}
+		//an op returned before it could be linearized: backtrack.
+		if len(calls) == 0 {
+			return r
+		}
+		f := calls[len(calls)-1]
+		calls = calls[:len(calls)-1]
+		state = f.state
+		done = done.clone()
+		done.clear(f.e.id)
+		unlift(f.e)
+		e = f.e.next
+	}
+	r.ok = true
+	return r
+}
+
+//String : timeline of the ops of a failed key, one row per op in call
+//order, with its place in the longest linearization found.
+func (r linResult) String() string {
+	const width = 60
+	idx := make([]int, len(r.ops))
+	var t0, t1 time.Duration = math.MaxInt64, 0
+	for i, o := range r.ops {
+		idx[i] = i
+		if o.call < t0 {
+			t0 = o.call
+		}
+		if o.ret > t1 {
+			t1 = o.ret
+		}
+		if o.call > t1 {
+			t1 = o.call
+		}
+	}
+	sort.SliceStable(idx, func(i, j int) bool { return r.ops[idx[i]].call < r.ops[idx[j]].call })
+	order := make(map[int]int)
+	for n, id := range r.best {
+		order[id] = n + 1
+	}
+	col := func(t time.Duration) int {
+		if t1 == t0 {
+			return 0
+		}
+		return int(int64(t-t0) * (width - 1) / int64(t1-t0))
+	}
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "key %q not linearizable: %d of %d ops linearized, then stuck.\n",
+		r.key, len(r.best), len(r.ops))
+	fmt.Fprintf(&sb, "%5s %-16s|%-*s| %s\n", "cli", "op", width, fmt.Sprintf("%v .. %v", t0, t1), "lin")
+	for _, i := range idx {
+		o := r.ops[i]
+		bar := []byte(strings.Repeat(" ", width))
+		c, e := col(o.call), width-1
+		if o.ret >= 0 {
+			e = col(o.ret)
+		}
+		for k := c; k <= e; k++ {
+			bar[k] = '-'
+		}
+		bar[c], bar[e] = '[', ']'
+		if o.ret < 0 {
+			bar[e] = '>'
+		}
+		if c == e {
+			bar[c] = '|'
+		}
+		lin := "-"
+		if n, ok := order[i]; ok {
+			lin = fmt.Sprint(n)
+		}
+		fmt.Fprintf(&sb, "%5d %-16s|%s| %s\n", o.cli, o, bar, lin)
+	}
+	return sb.String()
+}
+
+func TestLinearizabilityChecker(t *testing.T) {
+	ms := time.Millisecond
+	put := func(cli uint32, val string, call, ret time.Duration) *linOp {
+		return &linOp{cli: cli, op: KVOpPut, key: "k", val: val, call: call * ms, ret: ret * ms}
+	}
+	get := func(cli uint32, out string, call, ret time.Duration) *linOp {
+		return &linOp{cli: cli, op: KVOpGet, key: "k", out: out, call: call * ms, ret: ret * ms}
+	}
+	pending := func(o *linOp) *linOp {
+		o.ret = -1
+		return o
+	}
+	for i, tc := range []struct {
+		ok  bool
+		ops []*linOp
+	}{
+		{true, []*linOp{put(1, "a", 0, 10), get(2, "a", 20, 30)}},
+		{true, []*linOp{get(1, "", 0, 10), put(2, "a", 5, 15), get(3, "a", 6, 8)}},
+		//get sees b after a, while both puts overlap it.
+		{true, []*linOp{put(1, "a", 0, 10), put(2, "b", 0, 10), get(3, "b", 5, 20), get(3, "a", 25, 30)}},
+		//stale read after both puts completed.
+		{false, []*linOp{put(1, "a", 0, 10), put(2, "b", 20, 30), get(3, "a", 40, 50)}},
+		//values can't flip back.
+		{false, []*linOp{put(1, "a", 0, 100), put(2, "b", 0, 100), get(3, "a", 10, 20), get(3, "b", 30, 40), get(4, "a", 50, 60)}},
+		//pending put may take effect any time.
+		{true, []*linOp{pending(put(1, "x", 0, 0)), get(2, "", 5, 10), get(2, "x", 500, 510)}},
+		{false, []*linOp{pending(put(1, "x", 20, 0)), get(2, "x", 5, 10)}},
+		//pending get is dropped.
+		{true, []*linOp{put(1, "a", 0, 10), pending(get(2, "b", 20, 0))}},
+	} {
+		bad := checkLinearizable(tc.ops)
+		if (len(bad) == 0) != tc.ok {
+			t.Errorf("case %d: linearizable %v, want %v\n%s", i, len(bad) == 0, tc.ok, bad)
+		}
+	}
+
+	//keys are independent, the bad one is shown.
+	ops := []*linOp{put(1, "a", 0, 10), put(2, "b", 20, 30), get(3, "a", 40, 50)}
+	ops = append(ops, &linOp{cli: 4, op: KVOpGet, key: "j", out: "", call: 0, ret: 60 * ms})
+	bad := checkLinearizable(ops)
+	if len(bad) != 1 || bad[0].key != "k" || len(bad[0].best) != 2 {
+		t.Fatal("bad keys:", bad)
+	}
+	vis := bad[0].String()
+	for _, want := range []string{`key "k" not linearizable: 2 of 3 ops`, "put k=b", "get k:a", "[--", "| 2\n", "| -\n"} {
+		if !strings.Contains(vis, want) {
+			t.Errorf("visualization lacks %q:\n%s", want, vis)
+		}
+	}
+}
+
+//simKVHistory : clients 9..11 get and put keys a and b on a 5-node
+//cluster, through partitions and message loss; then the network heals.
+//Fails unless every call completes and the history is linearizable.
+func simKVHistory(seed int64) (*linHistory, error) {
+	s := NewSim(seed, simFaults)
+	newSimCluster(s, 5)
+	for id := uint32(10); id <= 11; id++ {
+		s.AddNode(simConfig(id, 5)).Start()
+	}
+	chk := NewSafetyChecker(simConfig(1, 5))
+	chk.Watch(s)
+
+	h := new(linHistory)
+	const nop = 6
+	for id := uint32(9); id <= 11; id++ {
+		n := s.Node(id)
+		if n.client == nil {
+			n.client = NewClient(n)
+		}
+		var next func(k int)
+		next = func(k int) {
+			if k == nop {
+				return
+			}
+			op := &KVOp{Op: KVOpGet, Key: string(rune('a' + s.rng.Intn(2)))}
+			if s.rng.Intn(2) == 0 {
+				op.Op, op.Val = KVOpPut, fmt.Sprintf("%d.%d", n.id, k)
+			}
+			o := h.invoke(s.Elapsed(), n.id, op)
+			n.Do(op, func(ret int, res string) {
+				o.complete(s.Elapsed(), res)
+				s.AfterFunc(time.Duration(s.rng.Intn(50))*time.Millisecond, func() { next(k + 1) })
+			})
+		}
+		s.AfterFunc(time.Duration(s.rng.Intn(50))*time.Millisecond, func() { next(0) })
+	}
+	s.RunFor(3 * time.Second)
+	s.Heal()
+	s.RunFor(60 * time.Second)
+
+	if err := chk.Err(); err != nil {
+		return h, err
+	}
+	for _, o := range h.ops {
+		if o.ret < 0 {
+			return h, fmt.Errorf("client %d: %s never completed", o.cli, o)
+		}
+	}
+	if len(h.ops) != 3*nop {
+		return h, fmt.Errorf("%d calls made", len(h.ops))
+	}
+	if bad := checkLinearizable(h.ops); len(bad) != 0 {
+		return h, fmt.Errorf("%s", bad[0])
+	}
+	return h, nil
+}
+
+//TestSimLinearizable : replay a failure with
+//go test -run TestSimLinearizable -simseed N
+func TestSimLinearizable(t *testing.T) {
+	seeds := []int64{*simSeed}
+	if *simSeed == 0 {
+		nseed := int64(300)
+		if testing.Short() {
+			nseed = 50
+		}
+		seeds = seeds[:0]
+		for seed := int64(1); seed <= nseed; seed++ {
+			seeds = append(seeds, seed)
+		}
+		log.SetOutput(io.Discard)
+		defer log.SetOutput(os.Stderr)
+	}
+	for _, seed := range seeds {
+		if _, err := simKVHistory(seed); err != nil {
+			t.Fatalf("seed %d: %s\nreplay: go test -run TestSimLinearizable -simseed %d\n", seed, err, seed)
+		}
+	}
+}
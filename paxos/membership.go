@@ -0,0 +1,332 @@
+package paxos
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/wilem/simple-paxos/config"
+	"github.com/wilem/simple-paxos/wire"
+)
+
+//MemberOpType : change of the cluster membership.
+type MemberOpType uint8
+
+const (
+	//MemberOpAdd : ID takes Role.
+	MemberOpAdd MemberOpType = 16
+	//MemberOpRemove : ID leaves Role.
+	MemberOpRemove MemberOpType = 17
+)
+
+//Role : protocol role of a server.
+type Role uint8
+
+const (
+	RoleProposer Role = 1
+	RoleAcceptor Role = 2
+	RoleLearner  Role = 3
+)
+
+//roleNames : of Role, as Roles names them.
+var roleNames = map[Role]string{RoleProposer: "proposer", RoleAcceptor: "acceptor", RoleLearner: "learner"}
+
+//ParseRole : Role named s.
+func ParseRole(s string) (Role, error) {
+	for r, name := range roleNames {
+		if name == s {
+			return r, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown role: %q", s)
+}
+
+func (r Role) String() string {
+	if name, ok := roleNames[r]; ok {
+		return name
+	}
+	return fmt.Sprintf("role(%d)", uint8(r))
+}
+
+//memberOpSize : type,cli,seq,op,role,id
+const memberOpSize = 1 + 4 + 4 + 1 + 1 + 4
+
+//ErrMemberOp : value is not a membership change.
+var ErrMemberOp = errors.New("member: bad op")
+
+//MemberOp : membership change, a client call like a KV op; chosen for
+//instance i, it holds from instance i+Alpha on.
+type MemberOp struct {
+	Cli, Seq uint32
+	Op       MemberOpType
+	Role     Role
+	ID       uint32
+}
+
+//Value : op encoded as ValueMemberOp,cli,seq,op,role,id; the type
+//keeps a KV op, whatever its key and val, from decoding as a MemberOp.
+func (o *MemberOp) Value() *wire.Value {
+	bs := make([]byte, memberOpSize)
+	bs[0] = byte(ValueMemberOp)
+	binary.LittleEndian.PutUint32(bs[1:], o.Cli)
+	binary.LittleEndian.PutUint32(bs[5:], o.Seq)
+	bs[9] = byte(o.Op)
+	bs[10] = byte(o.Role)
+	binary.LittleEndian.PutUint32(bs[11:], o.ID)
+	return wire.NewValue(bs)
+}
+
+//DecodeMemberOp : op carried in val.
+func DecodeMemberOp(val *wire.Value) (*MemberOp, error) {
+	bs := val.Oct
+	if len(bs) != memberOpSize || ValueType(bs[0]) != ValueMemberOp {
+		return nil, fmt.Errorf("%w: %d bytes", ErrMemberOp, len(bs))
+	}
+	o := new(MemberOp)
+	o.Cli = binary.LittleEndian.Uint32(bs[1:])
+	o.Seq = binary.LittleEndian.Uint32(bs[5:])
+	o.Op = MemberOpType(bs[9])
+	o.Role = Role(bs[10])
+	o.ID = binary.LittleEndian.Uint32(bs[11:])
+	if o.Cli == 0 || (o.Op != MemberOpAdd && o.Op != MemberOpRemove) || roleNames[o.Role] == "" {
+		return nil, fmt.Errorf("%w: cli:%d, op:%d, role:%d", ErrMemberOp, o.Cli, o.Op, o.Role)
+	}
+	return o, nil
+}
+
+//Members : proposers, acceptors and learners of the instances from IID on.
+type Members struct {
+	IID       uint32
+	Proposers []uint32
+	Acceptors []uint32
+	Learners  []uint32
+}
+
+//list : members of role r.
+func (m *Members) list(r Role) *[]uint32 {
+	switch r {
+	case RoleProposer:
+		return &m.Proposers
+	case RoleAcceptor:
+		return &m.Acceptors
+	default:
+		return &m.Learners
+	}
+}
+
+//quorum : acceptors a value needs to be chosen.
+func (m *Members) quorum() uint32 {
+	return uint32(len(m.Acceptors)/2 + 1)
+}
+
+//membership : members from the config, then as changed by the member
+//ops applied, in iid order. With the alpha window the members of iid
+//are known once all instances up to iid-alpha are applied.
+type membership struct {
+	alpha   uint32
+	servers []uint32
+	hist    []*Members           //by IID, the 1st one from iid 1
+	last    map[uint32]memberRes //client -> last op applied
+}
+
+//memberRes : result of the last op of a client, for its retries.
+type memberRes struct {
+	seq uint32
+	ret PxsStatus
+	res string
+}
+
+//newMembership : members of cfg from iid 1 on.
+func newMembership(cfg *config.ClusterConfig) *membership {
+	m := new(membership)
+	m.alpha = cfg.Alpha
+	m.servers = cfg.ServerList
+	m.hist = []*Members{{
+		IID:       1,
+		Proposers: append([]uint32(nil), cfg.ProposerList...),
+		Acceptors: append([]uint32(nil), cfg.AcceptorList...),
+		Learners:  append([]uint32(nil), cfg.LearnerList...),
+	}}
+	m.last = make(map[uint32]memberRes)
+	return m
+}
+
+//at : members of iid.
+func (m *membership) at(iid uint32) *Members {
+	for i := len(m.hist) - 1; i > 0; i-- {
+		if m.hist[i].IID <= iid {
+			return m.hist[i]
+		}
+	}
+	return m.hist[0]
+}
+
+//latest : members once all the ops applied hold.
+func (m *membership) latest() *Members {
+	return m.hist[len(m.hist)-1]
+}
+
+//apply : op chosen for iid; the members from iid+alpha on are the latest
+//ones, changed by op. An op which would leave no proposer or acceptor,
+//or a proposer which learns nothing, is rejected; so are all of them
+//with alpha 0.
+func (m *membership) apply(iid uint32, op *MemberOp) (PxsStatus, string) {
+	if l, ok := m.last[op.Cli]; ok && op.Seq <= l.seq {
+		return l.ret, l.res //retried
+	}
+	ret, res := PxsStatusMemberOpRejected, ""
+	cur := m.latest()
+	next := &Members{
+		IID:       iid + m.alpha,
+		Proposers: append([]uint32(nil), cur.Proposers...),
+		Acceptors: append([]uint32(nil), cur.Acceptors...),
+		Learners:  append([]uint32(nil), cur.Learners...),
+	}
+	lst := next.list(op.Role)
+	switch {
+	case m.alpha == 0:
+		res = "membership is fixed, Alpha is 0"
+	case !hasID(m.servers, op.ID):
+		res = fmt.Sprintf("node %d is not in ServerList", op.ID)
+	case op.Op == MemberOpAdd && hasID(*lst, op.ID):
+		res = fmt.Sprintf("node %d is a %s already", op.ID, op.Role)
+	case op.Op == MemberOpAdd && op.Role == RoleProposer && op.ID > config.MaxProposerID:
+		res = fmt.Sprintf("node %d is above %d, no proposer ID", op.ID, config.MaxProposerID)
+	case op.Op == MemberOpAdd && op.Role == RoleProposer && !hasID(next.Learners, op.ID):
+		res = fmt.Sprintf("node %d is no learner, a proposer must be", op.ID)
+	case op.Op == MemberOpAdd:
+		*lst = append(*lst, op.ID)
+		ret = PxsStatusOK
+	case !hasID(*lst, op.ID):
+		res = fmt.Sprintf("node %d is no %s", op.ID, op.Role)
+	case len(*lst) == 1 && op.Role != RoleLearner:
+		res = fmt.Sprintf("node %d is the last %s", op.ID, op.Role)
+	case op.Role == RoleLearner && hasID(next.Proposers, op.ID):
+		res = fmt.Sprintf("node %d is a proposer, it must stay a learner", op.ID)
+	default:
+		*lst = removeID(*lst, op.ID)
+		ret = PxsStatusOK
+	}
+	if ret == PxsStatusOK {
+		m.hist = append(m.hist, next)
+		res = fmt.Sprintf("from iid %d", next.IID)
+	}
+	m.last[op.Cli] = memberRes{op.Seq, ret, res}
+	return ret, res
+}
+
+//removeID : lst without id.
+func removeID(lst []uint32, id uint32) []uint32 {
+	out := lst[:0]
+	for _, v := range lst {
+		if v != id {
+			out = append(out, v)
+		}
+	}
+	return out
+}
+
+//applyMemberOp : op chosen for iid, applied by the learner; the roles
+//of this node follow the latest members.
+func (n *Node) applyMemberOp(iid uint32, op *MemberOp) (PxsStatus, string) {
+	ret, res := n.members.apply(iid, op)
+	log.Printf("[%d]Member op - iid:%d, op:%+v, ret:%d, res:%s\n", n.id, iid, op, ret, res)
+	if ret == PxsStatusOK && op.ID == n.id && op.Op == MemberOpAdd && op.Role == RoleProposer && n.proposer == nil {
+		n.proposer = NewProposer(n)
+		n.proposer.Start()
+	}
+	return ret, res
+}
+
+//takeAcceptor : a server which is no acceptor by its config becomes
+//one once a proposer sends it acceptor msgs, member ops made it one.
+//Only a proposer it knows of, or the member ops it learned, can make
+//it one. A new acceptor has promised nothing, which is safe.
+func (n *Node) takeAcceptor(from uint32) {
+	if n.acceptor != nil || n.members == nil || n.members.alpha == 0 || !hasID(n.cfg.ServerList, n.id) {
+		return
+	}
+	if cur := n.members.latest(); !hasID(cur.Proposers, from) && !hasID(cur.Acceptors, n.id) {
+		log.Printf("[%d]Acceptor msg from:%d dropped - not a proposer\n", n.id, from)
+		return
+	}
+	n.acceptor = NewAcceptor(n)
+	n.acceptor.Start()
+	log.Printf("[%d]Took role acceptor - asked by:%d\n", n.id, from)
+}
+
+//takeLearner : a server which is no learner by its config becomes one
+//once it gets the commit of the member op which made it one; or any
+//commit while it is no acceptor, commits go to learners else. It learns
+//the log from iid 1 on, from peers, and with it the members.
+func (n *Node) takeLearner(cmt *wire.PxsMsgCommit, from uint32) {
+	if n.learner != nil || n.members == nil || n.members.alpha == 0 || !hasID(n.cfg.ServerList, n.id) {
+		return
+	}
+	op, err := DecodeMemberOp(&cmt.Val)
+	mine := err == nil && op.Op == MemberOpAdd && op.Role == RoleLearner && op.ID == n.id
+	if !mine && n.acceptor != nil {
+		return
+	}
+	n.rsm = NewKVStore()
+	n.learner = NewLearner(n)
+	n.learner.Start()
+	log.Printf("[%d]Took role learner - commit from:%d\n", n.id, from)
+}
+
+//catchUp : ask learner dst for the values chosen from next on, one at a
+//time, until it knows no more; on no answer the other learners in turn.
+func (l *Learner) catchUp(dst uint32) {
+	if l.cu != nil { //in progress
+		return
+	}
+	l.cuTries = 0
+	l.query(dst)
+}
+
+//query : ask dst for the value chosen for next.
+func (l *Learner) query(dst uint32) {
+	bs, _ := wire.NewPxsMsgQuery(l.next, 0).Encode() //seq 0: to a learner
+	l.node.SendTo(dst, bs)
+	var t Timer
+	t = l.node.afterFunc(time.Duration(l.node.tuning.ClientTimeout), func() {
+		if l.cu != t {
+			return
+		}
+		l.cu = nil
+		peers := removeID(append([]uint32(nil), l.node.members.latest().Learners...), l.node.id)
+		if l.cuTries++; l.cuTries > len(peers) || len(peers) == 0 {
+			log.Printf("[%d]Learner catch up - no answer at iid:%d\n", l.node.id, l.next)
+			return
+		}
+		l.query(peerAfter(peers, dst))
+	})
+	l.cu = t
+}
+
+//OnRecvLog : answer to a query of catchUp.
+func (l *Learner) OnRecvLog(lg *wire.PxsMsgLog, from uint32) {
+	if l.cu == nil || lg.Hdr.IID != l.next { //late or duplicated
+		return
+	}
+	l.cu.Stop()
+	l.cu = nil
+	if lg.Ret != uint32(PxsStatusOK) { //from knows no more
+		return
+	}
+	l.cuTries = 0
+	l.learn(lg.Hdr.IID, &lg.Val)
+	l.query(from)
+}
+
+//peerAfter : id after dst in peers, the 1st one if dst is none.
+func peerAfter(peers []uint32, dst uint32) uint32 {
+	for i, id := range peers {
+		if id == dst {
+			return peers[(i+1)%len(peers)]
+		}
+	}
+	return peers[0]
+}
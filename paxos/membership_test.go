@@ -0,0 +1,279 @@
+package paxos
+
+import (
+	"errors"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/wilem/simple-paxos/config"
+	"github.com/wilem/simple-paxos/transport"
+	"github.com/wilem/simple-paxos/wire"
+)
+
+func TestMemberOp(t *testing.T) {
+	op := &MemberOp{Cli: 9, Seq: 3, Op: MemberOpAdd, Role: RoleAcceptor, ID: 4}
+	got, err := DecodeMemberOp(op.Value())
+	if err != nil || *got != *op {
+		t.Error("round trip:", got, err)
+	}
+	if _, err := DecodeKVOp(op.Value()); !errors.Is(err, ErrKVOp) {
+		t.Error("member op decoded as KV op:", err)
+	}
+	forged := op.Value().Oct
+	forged[0] = byte(ValueKVOp)
+	for _, bad := range []*wire.Value{
+		(&KVOp{Cli: 1, Seq: 1, Op: KVOpPut, Key: "", Val: "vvv"}).Value(), //same size
+		wire.NewValue(forged),
+		wire.NewValue(op.Value().Oct[1:]), //untagged
+		(&MemberOp{Cli: 0, Seq: 1, Op: MemberOpAdd, Role: RoleLearner}).Value(),
+		(&MemberOp{Cli: 1, Seq: 1, Op: MemberOpType(KVOpGet), Role: RoleLearner}).Value(),
+		(&MemberOp{Cli: 1, Seq: 1, Op: MemberOpRemove, Role: 9}).Value(),
+	} {
+		if _, err := DecodeMemberOp(bad); !errors.Is(err, ErrMemberOp) {
+			t.Error("bad op decoded:", bad.Oct, err)
+		}
+	}
+	if r, err := ParseRole("acceptor"); err != nil || r != RoleAcceptor || r.String() != "acceptor" {
+		t.Error("ParseRole:", r, err)
+	}
+}
+
+func TestMembership(t *testing.T) {
+	cfg := config.NewClusterConfig(1)
+	cfg.ServerList = append(seqIDs(4), 1<<16+1)
+	cfg.ProposerList = []uint32{1}
+	cfg.AcceptorList = seqIDs(3)
+	cfg.LearnerList = seqIDs(3)
+	cfg.Alpha = 3
+	m := newMembership(cfg)
+	seq := uint32(0)
+	apply := func(iid uint32, op MemberOpType, r Role, id uint32) (PxsStatus, string) {
+		seq++
+		return m.apply(iid, &MemberOp{Cli: 9, Seq: seq, Op: op, Role: r, ID: id})
+	}
+	if ret, res := apply(5, MemberOpAdd, RoleAcceptor, 4); ret != PxsStatusOK || res != "from iid 8" {
+		t.Fatal("add acceptor 4:", ret, res)
+	}
+	if ret, _ := apply(6, MemberOpRemove, RoleAcceptor, 1); ret != PxsStatusOK {
+		t.Fatal("remove acceptor 1:", ret)
+	}
+	for iid, want := range map[uint32][]uint32{1: {1, 2, 3}, 7: {1, 2, 3}, 8: {1, 2, 3, 4}, 9: {2, 3, 4}, 100: {2, 3, 4}} {
+		if got := m.at(iid).Acceptors; !reflect.DeepEqual(got, want) {
+			t.Errorf("acceptors of iid %d: %v", iid, got)
+		}
+	}
+	if m.at(8).quorum() != 3 || m.at(9).quorum() != 2 {
+		t.Error("quorums:", m.at(8).quorum(), m.at(9).quorum())
+	}
+	//retried: same result, applied once.
+	if ret, res := m.apply(7, &MemberOp{Cli: 9, Seq: seq, Op: MemberOpRemove, Role: RoleAcceptor, ID: 1}); ret != PxsStatusOK || len(m.hist) != 3 {
+		t.Error("retry:", ret, res, len(m.hist))
+	}
+	for _, c := range []struct {
+		op   MemberOpType
+		r    Role
+		id   uint32
+		want string
+	}{
+		{MemberOpAdd, RoleAcceptor, 5, "not in ServerList"},
+		{MemberOpAdd, RoleAcceptor, 4, "acceptor already"},
+		{MemberOpAdd, RoleProposer, 4, "no learner"},
+		{MemberOpAdd, RoleProposer, 1<<16 + 1, "no proposer ID"},
+		{MemberOpRemove, RoleProposer, 1, "last proposer"},
+		{MemberOpRemove, RoleLearner, 1, "must stay a learner"},
+		{MemberOpRemove, RoleLearner, 4, "no learner"},
+	} {
+		if ret, res := apply(10, c.op, c.r, c.id); ret != PxsStatusMemberOpRejected || !strings.Contains(res, c.want) {
+			t.Errorf("%d %s %d: %d %q", c.op, c.r, c.id, ret, res)
+		}
+	}
+	if len(m.hist) != 3 {
+		t.Error("rejected ops changed the members:", len(m.hist))
+	}
+
+	cfg.Alpha = 0
+	m = newMembership(cfg)
+	if ret, _ := apply(5, MemberOpAdd, RoleAcceptor, 4); ret != PxsStatusMemberOpRejected {
+		t.Error("change with Alpha 0:", ret)
+	}
+}
+
+//TestNodeReconfigure : a failed acceptor is replaced by a spare server
+//while the cluster runs; the spare keeps the role across a restart.
+func TestNodeReconfigure(t *testing.T) {
+	fabric := transport.NewMemFabric()
+	nodes := make(map[uint32]*Node)
+	for _, id := range []uint32{1, 2, 3, 4, 9} {
+		cfg := config.NewClusterConfig(id)
+		cfg.ServerList = seqIDs(4) //4: spare
+		cfg.ProposerList = []uint32{1}
+		cfg.AcceptorList = seqIDs(3)
+		cfg.LearnerList = seqIDs(3)
+		cfg.Alpha = 2
+		nodes[id] = NewNodeConfig(cfg, fabric.NewTransport(id))
+		if err := nodes[id].Start(); err != nil {
+			t.Fatal("Start:", err)
+		}
+	}
+	fabric.Drain(0)
+	n := uint32(0)
+	put := func() {
+		t.Helper()
+		n++
+		ret := -1
+		if err := nodes[9].Do(&KVOp{Op: KVOpPut, Key: "k", Val: string(rune('0' + n%10))}, func(r int, _ string) { ret = r }); err != nil {
+			t.Fatal("Do:", err)
+		}
+		fabric.Drain(0)
+		if ret != int(PxsStatusOK) {
+			t.Fatalf("put %d: ret %d", n, ret)
+		}
+	}
+	change := func(op MemberOpType, r Role, id uint32) (ret int, res string) {
+		t.Helper()
+		ret = -1
+		if err := nodes[9].Reconfigure(&MemberOp{Op: op, Role: r, ID: id}, func(rt int, rs string) { ret, res = rt, rs }); err != nil {
+			t.Fatal("Reconfigure:", err)
+		}
+		fabric.Drain(0)
+		return
+	}
+	put()
+	//node 3 is no proposer: its prepare makes no acceptor of node 4.
+	prp, _ := wire.NewPxsMsgPrepare(2, 103).Encode()
+	nodes[3].SendTo(4, prp)
+	fabric.Drain(0)
+	if slices.Contains(nodes[4].Roles(), "acceptor") {
+		t.Fatal("node 4 made an acceptor by node 3:", nodes[4].Roles())
+	}
+	if ret, res := change(MemberOpAdd, RoleAcceptor, 4); ret != int(PxsStatusOK) || res != "from iid 4" {
+		t.Fatal("add acceptor 4:", ret, res)
+	}
+	if ret, res := change(MemberOpRemove, RoleAcceptor, 3); ret != int(PxsStatusOK) || res != "from iid 5" {
+		t.Fatal("remove acceptor 3:", ret, res)
+	}
+	if m := nodes[2].Members(3); !reflect.DeepEqual(m.Acceptors, []uint32{1, 2, 3}) {
+		t.Error("members of iid 3:", m)
+	}
+	if m := nodes[2].Members(0); m.IID != 5 || !reflect.DeepEqual(m.Acceptors, []uint32{1, 2, 4}) {
+		t.Error("latest members:", m)
+	}
+	put() //iid 4, with acceptor 4
+	if !slices.Contains(nodes[4].Roles(), "acceptor") {
+		t.Fatal("node 4 roles:", nodes[4].Roles())
+	}
+	//node 3 fails, 1,2,4 go on.
+	if err := nodes[3].Stop(); err != nil {
+		t.Fatal("Stop:", err)
+	}
+	put()
+	if ret, _ := change(MemberOpRemove, RoleProposer, 1); ret != int(PxsStatusMemberOpRejected) {
+		t.Error("removed the last proposer:", ret)
+	}
+	//node 4 keeps its acceptor state.
+	if err := nodes[4].Stop(); err != nil {
+		t.Fatal("Stop:", err)
+	}
+	if err := nodes[4].Start(); err != nil {
+		t.Fatal("Start:", err)
+	}
+	fabric.Drain(0)
+	if !slices.Contains(nodes[4].Roles(), "acceptor") || len(nodes[4].acceptor.maxVal) == 0 {
+		t.Fatal("node 4 acceptor not recovered:", nodes[4].Roles())
+	}
+	put()
+	if v, _ := nodes[2].rsm.(*KVStore).Get("k"); v != "4" {
+		t.Error("k =", v)
+	}
+}
+
+//TestNodeAddLearner : a spare server made a learner learns the log from
+//the start, members included; made a proposer then, it runs with the
+//members of the cluster.
+func TestNodeAddLearner(t *testing.T) {
+	fabric := transport.NewMemFabric()
+	nodes := make(map[uint32]*Node)
+	for _, id := range []uint32{1, 2, 3, 4, 9} {
+		cfg := config.NewClusterConfig(id)
+		cfg.ServerList = seqIDs(4) //4: spare
+		cfg.ProposerList = []uint32{1}
+		cfg.AcceptorList = seqIDs(3)
+		cfg.LearnerList = seqIDs(3)
+		cfg.Alpha = 2
+		nodes[id] = NewNodeConfig(cfg, fabric.NewTransport(id))
+		if err := nodes[id].Start(); err != nil {
+			t.Fatal("Start:", err)
+		}
+	}
+	fabric.Drain(0)
+	do := func(op *KVOp) {
+		t.Helper()
+		ret := -1
+		if err := nodes[9].Do(op, func(r int, _ string) { ret = r }); err != nil {
+			t.Fatal("Do:", err)
+		}
+		fabric.Drain(0)
+		if ret != int(PxsStatusOK) {
+			t.Fatalf("%+v: ret %d", op, ret)
+		}
+	}
+	change := func(op MemberOpType, r Role, id uint32) {
+		t.Helper()
+		ret, res := -1, ""
+		if err := nodes[9].Reconfigure(&MemberOp{Op: op, Role: r, ID: id}, func(rt int, rs string) { ret, res = rt, rs }); err != nil {
+			t.Fatal("Reconfigure:", err)
+		}
+		fabric.Drain(0)
+		if ret != int(PxsStatusOK) {
+			t.Fatalf("%d %s %d: %d %s", op, r, id, ret, res)
+		}
+	}
+	do(&KVOp{Op: KVOpPut, Key: "k", Val: "1"})
+	change(MemberOpAdd, RoleAcceptor, 4)
+	change(MemberOpRemove, RoleAcceptor, 3)
+	change(MemberOpAdd, RoleLearner, 4)
+	do(&KVOp{Op: KVOpPut, Key: "k", Val: "2"})
+	if !slices.Contains(nodes[4].Roles(), "learner") {
+		t.Fatal("node 4 roles:", nodes[4].Roles())
+	}
+	if v, _ := nodes[4].rsm.(*KVStore).Get("k"); v != "2" {
+		t.Error("node 4 not caught up: k =", v)
+	}
+	change(MemberOpAdd, RoleProposer, 4)
+	do(&KVOp{Op: KVOpPut, Key: "k", Val: "3"}) //members of node 4 from here on
+	if !slices.Contains(nodes[4].Roles(), "proposer") {
+		t.Fatal("node 4 roles:", nodes[4].Roles())
+	}
+	if m1, m4 := nodes[1].Members(0), nodes[4].Members(0); !reflect.DeepEqual(m1, m4) {
+		t.Errorf("members of node 4: %+v, of node 1: %+v", m4, m1)
+	}
+	//node 4 proposes; acceptor 3 is gone, 1,2,4 choose.
+	if err := nodes[3].Stop(); err != nil {
+		t.Fatal("Stop:", err)
+	}
+	op := &KVOp{Cli: 9, Seq: 100, Op: KVOpPut, Key: "k", Val: "4"}
+	bs, _ := wire.NewPxsMsgRequest(op.Seq, op.Value()).Encode()
+	nodes[9].SendTo(4, bs)
+	fabric.Drain(0)
+	for _, id := range []uint32{1, 2, 4} {
+		if v, _ := nodes[id].rsm.(*KVStore).Get("k"); v != "4" {
+			t.Errorf("node %d: k = %s", id, v)
+		}
+	}
+	//node 4 keeps the role across a restart.
+	if err := nodes[4].Stop(); err != nil {
+		t.Fatal("Stop:", err)
+	}
+	if err := nodes[4].Start(); err != nil {
+		t.Fatal("Start:", err)
+	}
+	fabric.Drain(0)
+	if roles := nodes[4].Roles(); !slices.Contains(roles, "learner") || !slices.Contains(roles, "proposer") {
+		t.Error("node 4 roles after restart:", roles)
+	}
+	if v, _ := nodes[4].rsm.(*KVStore).Get("k"); v != "4" {
+		t.Error("node 4 after restart: k =", v)
+	}
+}
@@ -0,0 +1,47 @@
+package paxos
+
+import (
+	"bytes"
+	"log"
+	"testing"
+
+	"github.com/wilem/simple-paxos/transport"
+	"github.com/wilem/simple-paxos/wire"
+)
+
+//TestNetSimPaxos : proposers 1..3 race for the same instances on a
+//faulty network; the values they commit must agree per instance.
+func TestNetSimPaxos(t *testing.T) {
+	faults := transport.NetFaults{
+		Drop: 0.05, Dup: 0.05, Delay: 0.3, MaxDelay: 20, Reorder: 0.3,
+		PartitionEvery: 50, Partition: 0.3,
+	}
+	var ncommitted int
+	for seed := int64(1); seed <= 200; seed++ {
+		fabric := transport.NewMemFabric()
+		nodes := newMemCluster(t, fabric, 5)
+		sim := transport.NewNetSim(fabric, seed, faults)
+		for seq := uint32(1); seq <= 6; seq++ {
+			val := wire.NewValue([]byte{byte(seed), byte(seq)})
+			bs, _ := wire.NewPxsMsgRequest(seq, val).Encode()
+			nodes[9].SendTo(seq%3+1, bs)
+			sim.Run(int(seed % 40))
+		}
+		sim.Run(100000)
+
+		committed := make(map[uint32][]byte) //iid -> value
+		for _, id := range []uint32{1, 2, 3} {
+			for iid, val := range nodes[id].learner.chosen {
+				if v, ok := committed[iid]; ok && !bytes.Equal(v, val.Oct) {
+					t.Fatalf("seed %d: iid %d committed %v and %v\n", seed, iid, v, val.Oct)
+				}
+				committed[iid] = val.Oct
+			}
+		}
+		ncommitted += len(committed)
+	}
+	if ncommitted == 0 {
+		t.Error("nothing committed")
+	}
+	log.Println("instances committed:", ncommitted)
+}
@@ -0,0 +1,746 @@
+package paxos
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"math/rand"
+	"sync/atomic"
+	"time"
+
+	"github.com/wilem/simple-paxos/config"
+	"github.com/wilem/simple-paxos/storage"
+	"github.com/wilem/simple-paxos/transport"
+	"github.com/wilem/simple-paxos/wire"
+)
+
+//PxsStatus : status
+type PxsStatus int
+
+const (
+	//PxsStatusOK : OK
+	PxsStatusOK PxsStatus = 0
+	//PxsStatusClusterUnavailable : Cluster is unavailable;
+	PxsStatusClusterUnavailable PxsStatus = 1
+	//PxsStatusNotProposerLeader : current proposer is not leader;
+	PxsStatusNotProposerLeader PxsStatus = 2
+	//PxsStatusNetworkIOFailure :
+	PxsStatusNetworkIOFailure PxsStatus = 3
+	//PxsStatusValueTooLarge : value exceeds the configured max value size;
+	PxsStatusValueTooLarge PxsStatus = 4
+	//PxsStatusNotChosen : no value is chosen for the instance yet, as far as the learner knows;
+	PxsStatusNotChosen PxsStatus = 5
+	//PxsStatusMemberOpRejected : membership change not made, the result tells why;
+	PxsStatusMemberOpRejected PxsStatus = 6
+	//PxsStatusStorageFailure : state could not be saved to stable storage;
+	PxsStatusStorageFailure PxsStatus = 7
+)
+
+//NodeStats : counters of a node.
+type NodeStats struct {
+	CorruptFrames uint64 //frames dropped for checksum mismatch
+	AuthFailures  uint64 //frames dropped for bad MAC or sender
+	RecvWaits     uint64 //times a transport waited for the event loop
+	CfgMismatches uint64 //hellos of servers with another cluster config
+}
+
+//RecvQueueSize : most msgs received and not handled yet; the transport
+//delivering one more waits, so the kernel or the sender slows down.
+const RecvQueueSize = 256
+
+//INode communication.
+type INode interface {
+	GetID() uint32
+	Start() error
+	Stop() error
+	OnRecv(src uint32, data []byte)
+	//send to some node with ID src
+	SendTo(dst uint32, data []byte) (int, error)
+}
+
+//Node :
+type Node struct {
+	id     uint32
+	cfg    *config.ClusterConfig           //proposer,acceptor,learner config
+	trans  transport.ITransport            //UDP, TCP, ...
+	clock  Clock                           //timers of protocol roles.
+	rng    *rand.Rand                      //timeout jitter.
+	loop   eventLoop                       //runs msgs, timers and API calls.
+	recvQ  chan struct{}                   //a slot per msg received, not handled yet.
+	store  storage.Storage                 //persistent state.
+	up     bool                            //started, not stopped.
+	epoch  uint32                          //starts so far, timers of older ones are void.
+	bufMap map[uint32]*bytes.Buffer        //incoming peer msg buffers.
+	asmMap map[uint32]*wire.PxsMsgAssembly //incoming peer fragmented msg.
+	fragID uint32                          //last outgoing fragmented msg ID, atomic.
+	//log of the persistent state
+	unsaved  []storage.Record //chosen since the last append, saved with the next one
+	logged   int              //records appended since the last snapshot
+	snapSize int              //records in the last snapshot
+	//node/cluster config
+	peers   []uint32    //peers ID
+	members *membership //of every instance, as the log is applied
+	//protocol roles:
+	client   *Client
+	proposer *Proposer
+	acceptor *Acceptor
+	learner  *Learner
+	rsm      StateMachine //applied by learner
+	//persistent states
+	instanceID uint32 //globally auto incremental instance ID
+	leaderID   uint32 //leader proposer ID
+	//peers that said hello with our version and config
+	hello   map[uint32]bool
+	cfgHash uint64          //of cfg, sent in hello
+	tuning  config.Tuning   //of cfg, defaults filled in
+	reject  map[uint32]bool //servers with another cluster config, msgs dropped
+	stats   NodeStats
+}
+
+//NewNode : ctor of Node, on UDP transport
+func NewNode(id uint32) *Node {
+	return NewNodeTransport(id, transport.NewUDPTransport(id))
+}
+
+//NewNodeTransport : ctor of Node on given transport
+func NewNodeTransport(id uint32, trans transport.ITransport) *Node {
+	n := new(Node)
+	n.id = id
+	n.trans = trans
+	n.trans.SetOnRecv(n.OnRecv)
+	n.clock = realClock{}
+	n.rng = rand.New(rand.NewSource(time.Now().UnixNano() + int64(id)))
+	n.bufMap = make(map[uint32]*bytes.Buffer)
+	n.asmMap = make(map[uint32]*wire.PxsMsgAssembly)
+	n.hello = make(map[uint32]bool)
+	n.reject = make(map[uint32]bool)
+	n.tuning = config.DefaultTuning
+	n.recvQ = make(chan struct{}, RecvQueueSize)
+	n.rsm = NewKVStore()
+	n.store = storage.NewMemStorage()
+	n.instanceID = 1
+	return n
+}
+
+//GetID : return ID.
+func (n *Node) GetID() uint32 {
+	return n.id
+}
+
+//NewNodeLoad : generate node from config file
+func NewNodeLoad(cfgFile string) (*Node, error) {
+	//new cfg
+	cfg := new(config.ClusterConfig)
+	if err := cfg.LoadFromFile(cfgFile); err != nil {
+		return nil, fmt.Errorf("load config %s: %w", cfgFile, err)
+	}
+	return NewNodeCluster(cfg)
+}
+
+//NewNodeCluster : generate node from config, validated, on the
+//transport it names.
+func NewNodeCluster(cfg *config.ClusterConfig) (*Node, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	tlsCfg, err := transport.LoadTLSConfig(cfg.TLSCA, cfg.TLSCert, cfg.TLSKey)
+	if err != nil {
+		return nil, fmt.Errorf("load TLS config: %w", err)
+	}
+	//new node with cfg
+	var trans transport.ITransport
+	switch cfg.Transport {
+	case "", config.TransportUDP:
+		book := cfg.Addrs
+		if book == nil { //servers on legacy ports, their routes are not learned.
+			book = transport.LegacyAddrBook(cfg.ServerList)
+		}
+		udp := transport.NewUDPTransportAddrs(cfg.NodeID, book)
+		udp.Listen = cfg.Listen
+		if cfg.Secret != "" {
+			udp.Secret = []byte(cfg.Secret)
+		}
+		trans = udp
+	case config.TransportTCP:
+		tcp := transport.NewTCPTransportTLS(cfg.NodeID, cfg.Addrs, tlsCfg)
+		tcp.Listen = cfg.Listen
+		trans = tcp
+	}
+	return NewNodeConfig(cfg, trans), nil
+}
+
+//NewNodeConfig : generate node from config on given transport
+func NewNodeConfig(cfg *config.ClusterConfig, trans transport.ITransport) *Node {
+	node := NewNodeTransport(cfg.NodeID, trans)
+	node.cfg = cfg
+	node.cfgHash = cfg.Hash()
+	node.tuning = cfg.Tuning.WithDefaults()
+	if cfg.DataDir != "" {
+		node.store = storage.NewFileStorage(cfg.DataDir, cfg.NodeID)
+	}
+	//peer list: init buffer.
+	for _, id := range node.cfg.ServerList {
+		node.bufMap[id] = new(bytes.Buffer)
+	}
+	//TODO to be chose by leader election proposal
+	node.leaderID = 0 //leader is not selected
+	return node
+}
+
+//Start - start transport server
+func (n *Node) Start() error {
+	if n.cfg == nil {
+		return fmt.Errorf("node %d has no config", n.id)
+	}
+	var err error
+	n.loop.exec(func() { err = n.start() })
+	if err != nil {
+		return err
+	}
+	if _, ok := n.trans.(inlineTransport); !ok {
+		n.loop.start()
+	}
+
+	//start transport
+	err = n.trans.Start()
+	if err != nil {
+		log.Printf("[%d]Start transport FAILED - err:%s\n", n.id, err)
+		n.loop.exec(func() { n.stop() })
+		n.loop.stop()
+		return fmt.Errorf("node %d start transport: %w", n.id, err)
+	}
+
+	//announce protocol versions to peers
+	n.sayHello()
+
+	return err
+}
+
+//start : create protocol roles, recover their state.
+func (n *Node) start() error {
+	if n.up {
+		return fmt.Errorf("node %d already started", n.id)
+	}
+	st, err := n.store.Load()
+	if err != nil {
+		log.Printf("[%d]Load state FAILED - err:%s\n", n.id, err)
+		return err
+	}
+
+	//members of the config, changed as the log is applied again.
+	n.members = newMembership(n.cfg)
+	n.proposer, n.acceptor, n.learner = nil, nil, nil //the ones taken are recovered.
+
+	//start proposer
+	for _, v := range n.cfg.ProposerList {
+		if v == n.id {
+			n.proposer = NewProposer(n)
+			n.proposer.Start()
+		}
+	}
+
+	//start acceptor
+	for _, v := range n.cfg.AcceptorList {
+		if v == n.id {
+			n.acceptor = NewAcceptor(n)
+			n.acceptor.Start()
+		}
+	}
+
+	//start learner
+	for _, v := range n.cfg.LearnerList {
+		if v == n.id {
+			n.rsm = NewKVStore() //rebuilt from the values chosen
+			n.learner = NewLearner(n)
+			n.learner.Start()
+		}
+	}
+
+	//start client: listed, or not a server at all.
+	if hasID(n.cfg.ClientList, n.id) || !hasID(n.cfg.ServerList, n.id) {
+		n.client = NewClient(n)
+		n.client.Start()
+	}
+
+	//recover from stable storage
+	if st != nil {
+		n.restore(st)
+	}
+	n.epoch++
+	n.up = true
+	return nil
+}
+
+//Stop : stop transport and timers, flush persistent state. A node
+//stopped may be started again, it recovers what was flushed.
+func (n *Node) Stop() error {
+	err := n.trans.Stop() //no OnRecv once it returns.
+	n.loop.exec(func() {
+		if serr := n.stop(); serr != nil {
+			err = serr
+		}
+	})
+	n.loop.stop()
+	return err
+}
+
+//stop : end the work in progress, flush persistent state.
+func (n *Node) stop() error {
+	if !n.up {
+		return nil
+	}
+	n.up = false //timers pending are void.
+	if n.client != nil {
+		n.client.Stop()
+	}
+	if n.proposer != nil {
+		n.proposer.Stop()
+	}
+	//partial msgs would never be completed.
+	for _, buf := range n.bufMap {
+		buf.Reset()
+	}
+	n.asmMap = make(map[uint32]*wire.PxsMsgAssembly)
+	return n.persist()
+}
+
+//OnRecv : on data recv from transport, handled on the event loop, then
+//given back to the pool. Waits while RecvQueueSize msgs are queued.
+func (n *Node) OnRecv(from uint32, data []byte) {
+	select {
+	case n.recvQ <- struct{}{}:
+	default:
+		atomic.AddUint64(&n.stats.RecvWaits, 1)
+		n.recvQ <- struct{}{}
+	}
+	n.loop.post(func() {
+		<-n.recvQ
+		n.recv(from, data)
+		transport.PutRecvBuf(data)
+	})
+}
+
+//recv : decode and dispatch all complete msgs from peer.
+func (n *Node) recv(from uint32, data []byte) {
+	//log.Printf("[%d]Node.OnRecv - from:%d,data:%+v\n", n.id, from, data)
+	buf, ok := n.bufMap[from]
+	if !ok {
+		//log.Println("DROPED.")
+		n.bufMap[from] = new(bytes.Buffer)
+		buf = n.bufMap[from]
+	}
+	//decode all complete msgs in buffer.
+	for bs := data; ; bs = nil {
+		msg, hdr, rem, err := n.decode(buf, bs, from)
+		switch {
+		case errors.Is(err, wire.ErrPxsMsgIncomplete):
+			if rem != 0 {
+				log.Printf("Decode rem:%d, expect more.\n", rem)
+			}
+			return
+		case errors.Is(err, wire.ErrPxsMsgMagic), errors.Is(err, wire.ErrPxsMsgTooLarge): //nothing consumed
+			log.Printf("[%d]Decode failed - from:%d, hdr:%+v, err:%s, buffer reset.\n", n.id, from, hdr, err)
+			buf.Reset()
+			return
+		case err != nil: //bad frame skipped, go on with the next one.
+			n.countBadFrame(err)
+			log.Printf("[%d]Decode failed - from:%d, hdr:%+v, err:%s, frame dropped.\n", n.id, from, hdr, err)
+			continue
+		}
+		n.dispatch(msg, hdr, from)
+	}
+}
+
+//dispatch : handle one incoming msg
+func (n *Node) dispatch(msg interface{}, hdr *wire.PxsMsgHeader, from uint32) {
+	if n.reject[from] && hdr.Typ != wire.PxsMsgTypeHello {
+		return
+	}
+	switch hdr.Typ {
+	case wire.PxsMsgTypeHello: //PxsMsgType = 0x00 //00 msg: node <-> node
+		n.OnRecvHello(msg.(*wire.PxsMsgHello), from)
+	case wire.PxsMsgTypeRequest: //PxsMsgType = 0x0a //0a msg: pro -> cli
+		if n.proposer != nil {
+			req := msg.(*wire.PxsMsgRequest)
+			sts := n.proposer.OnRecvRequest(req, from)
+			if sts != 0 { // reply early
+				rsp := wire.NewPxsMsgResponse(req.Hdr.IID, uint32(sts), nil)
+				bs, _ := rsp.Encode()
+				n.SendTo(from, bs)
+			}
+		}
+	case wire.PxsMsgTypePrepare: //PxsMsgType = 0x1a //1a msg: pro -> acc
+		n.takeAcceptor(from)
+		if n.acceptor != nil {
+			prp := msg.(*wire.PxsMsgPrepare)
+			n.acceptor.OnRecvPrepare(prp, from)
+		}
+	case wire.PxsMsgTypePromise: //PxsMsgType = 0x1b //1b msg: acc -> pro
+		if n.proposer != nil {
+			pro := msg.(*wire.PxsMsgPromise)
+			n.proposer.OnRecvPromise(pro, from)
+		}
+	case wire.PxsMsgTypeAccept: //PxsMsgType = 0x2a //2a msg: pro -> acc
+		n.takeAcceptor(from)
+		if n.acceptor != nil {
+			acc := msg.(*wire.PxsMsgAccept)
+			n.acceptor.OnRecvAccept(acc, from)
+		}
+	case wire.PxsMsgTypeAccepted: //PxsMsgType = 0x2b //2b msg: acc -> pro
+		if n.proposer != nil {
+			acd := msg.(*wire.PxsMsgAccepted)
+			n.proposer.OnRecvAccepted(acd, from)
+		}
+	case wire.PxsMsgTypeCommit: //PxsMsgType = 0x3a //3a msg: pro -> acc
+		cmt := msg.(*wire.PxsMsgCommit)
+		if n.acceptor != nil {
+			n.acceptor.OnRecvCommit(cmt, from)
+		}
+		n.takeLearner(cmt, from)
+		if n.learner != nil {
+			n.learner.OnRecvCommit(cmt, from)
+		}
+		n.setLeader(from)
+	case wire.PxsMsgTypeFragment: //PxsMsgType = 0xf0 //f0 msg: node -> node
+		n.OnRecvFragment(msg.(*wire.PxsMsgFragment), from)
+	case wire.PxsMsgTypeResponse: //PxsMsgType = 0x0b //0b msg: pro -> cli
+		if n.client != nil {
+			rsp := msg.(*wire.PxsMsgResponse)
+			sts := n.client.OnRecvResponse(rsp, from)
+			log.Printf("client.OnRecvResponse - ret:%d\n", sts)
+		}
+	case wire.PxsMsgTypeQuery: //PxsMsgType = 0x0c //0c msg: cli -> lrn
+		if n.learner != nil {
+			n.learner.OnRecvQuery(msg.(*wire.PxsMsgQuery), from)
+		}
+	case wire.PxsMsgTypeLog: //PxsMsgType = 0x0d //0d msg: lrn -> cli, lrn
+		lg := msg.(*wire.PxsMsgLog)
+		if lg.Seq == 0 && n.learner != nil { //to a learner catching up
+			n.learner.OnRecvLog(lg, from)
+		} else if n.client != nil {
+			n.client.OnRecvLog(lg, from)
+		}
+	}
+}
+
+//setLeader : proposer id committed a value, it leads for now.
+func (n *Node) setLeader(id uint32) {
+	if n.leaderID != id {
+		n.leaderID = id //update leader ID
+		log.Printf("[%d]Leader node ID changed to %d\n", n.id, id)
+	}
+}
+
+//decode : next msg in buf, which must be sealed by from with the
+//cluster secret, if there is one.
+func (n *Node) decode(buf *bytes.Buffer, bs []byte, from uint32) (interface{}, *wire.PxsMsgHeader, int, error) {
+	key := n.secret()
+	if key == nil {
+		return wire.DecodeOnePxsMsg(buf, bs)
+	}
+	msg, hdr, src, rem, err := wire.DecodeOnePxsMsgAuth(buf, bs, key)
+	if err == nil && src != from {
+		return nil, hdr, rem, fmt.Errorf("%w: from:%d, sealed by:%d", wire.ErrPxsMsgAuth, from, src)
+	}
+	return msg, hdr, rem, err
+}
+
+//countBadFrame : update stats with a decode error.
+func (n *Node) countBadFrame(err error) {
+	switch {
+	case errors.Is(err, wire.ErrPxsMsgChecksum):
+		atomic.AddUint64(&n.stats.CorruptFrames, 1)
+	case errors.Is(err, wire.ErrPxsMsgAuth):
+		atomic.AddUint64(&n.stats.AuthFailures, 1)
+	}
+}
+
+//secret : cluster shared secret, nil if frames are not sealed.
+func (n *Node) secret() []byte {
+	if n.cfg == nil || n.cfg.Secret == "" {
+		return nil
+	}
+	return []byte(n.cfg.Secret)
+}
+
+//OnRecvFragment : reassemble a large msg, handle it once all pieces arrived.
+func (n *Node) OnRecvFragment(frg *wire.PxsMsgFragment, from uint32) {
+	asm, ok := n.asmMap[from]
+	if !ok {
+		asm = new(wire.PxsMsgAssembly)
+		n.asmMap[from] = asm
+	}
+	frm, err := asm.Add(frg)
+	if err != nil {
+		log.Printf("[%d]Fragment dropped - from:%d, err:%s\n", n.id, from, err)
+		return
+	}
+	if frm == nil { //wait for more pieces
+		return
+	}
+	var buf bytes.Buffer
+	msg, hdr, _, err := n.decode(&buf, frm, from)
+	if err == nil && hdr.Typ == wire.PxsMsgTypeFragment {
+		err = errors.New("nested fragment")
+	}
+	if err != nil {
+		n.countBadFrame(err)
+		log.Printf("[%d]Reassembled msg dropped - from:%d, err:%s\n", n.id, from, err)
+		return
+	}
+	n.dispatch(msg, hdr, from)
+}
+
+//afterFunc : run f on the node clock, as an event; not run if the node
+//is stopped meanwhile.
+func (n *Node) afterFunc(d time.Duration, f func()) Timer {
+	epoch := n.epoch
+	return n.clock.AfterFunc(d, func() {
+		n.loop.post(func() {
+			if n.epoch != epoch || !n.up { //stopped since
+				return
+			}
+			f()
+		})
+	})
+}
+
+//sayHello : send local version range and config hash to all peers.
+func (n *Node) sayHello() {
+	bs, _ := wire.NewPxsMsgHello(n.cfgHash).Encode()
+	for _, id := range n.cfg.ServerList {
+		if id != n.id {
+			n.SendTo(id, bs)
+		}
+	}
+}
+
+//OnRecvHello : check protocol version of peer; a server with another
+//cluster config is rejected, until it says hello with ours.
+func (n *Node) OnRecvHello(hlo *wire.PxsMsgHello, from uint32) {
+	if err := hlo.CheckVersion(); err != nil {
+		log.Printf("[%d]Hello from:%d rejected - err:%s\n", n.id, from, err)
+		return
+	}
+	known := n.hello[from]
+	rejected := n.reject[from]
+	if hlo.CfgHash != n.cfgHash && hlo.CfgHash != 0 && n.cfgHash != 0 && //0: no config to tell
+		hasID(n.cfg.ServerList, n.id) && hasID(n.cfg.ServerList, from) { //clients may differ
+		atomic.AddUint64(&n.stats.CfgMismatches, 1)
+		log.Printf("[%d]Hello from:%d rejected - cluster config hash:%016x, ours:%016x\n",
+			n.id, from, hlo.CfgHash, n.cfgHash)
+		delete(n.hello, from)
+		n.reject[from] = true
+		known = known || rejected //told already
+	} else {
+		delete(n.reject, from)
+		n.hello[from] = true
+		log.Printf("[%d]Hello from:%d\n", n.id, from)
+	}
+	if !known { //peer may have started after us, answer once.
+		bs, _ := wire.NewPxsMsgHello(n.cfgHash).Encode()
+		n.SendTo(from, bs)
+	}
+}
+
+//Call : Client.Call on the event loop, done is run there too; not to be
+//called from done.
+func (n *Node) Call(val *wire.Value, done func(ret int, res *wire.Value)) (seq uint32, err error) {
+	n.loop.exec(func() {
+		if n.client == nil {
+			err = ErrNoClient
+			return
+		}
+		seq, err = n.client.Call(val, done)
+	})
+	return
+}
+
+//Do : Client.Do on the event loop, like Call.
+func (n *Node) Do(op *KVOp, done func(ret int, res string)) (err error) {
+	n.loop.exec(func() {
+		if n.client == nil {
+			err = ErrNoClient
+			return
+		}
+		err = n.client.Do(op, done)
+	})
+	return
+}
+
+//Reconfigure : Client.Reconfigure on the event loop, like Call.
+func (n *Node) Reconfigure(op *MemberOp, done func(ret int, res string)) (err error) {
+	n.loop.exec(func() {
+		if n.client == nil {
+			err = ErrNoClient
+			return
+		}
+		err = n.client.Reconfigure(op, done)
+	})
+	return
+}
+
+//Members : members of instance iid as this node knows them, iid 0 for
+//the latest ones; the config lists if the node is not started.
+func (n *Node) Members(iid uint32) (m Members) {
+	n.loop.exec(func() {
+		ms := n.members
+		if ms == nil {
+			ms = newMembership(n.cfg)
+		}
+		if iid == 0 {
+			m = *ms.latest()
+		} else {
+			m = *ms.at(iid)
+		}
+	})
+	return
+}
+
+//Query : Client.Query on the event loop, like Call.
+func (n *Node) Query(iid uint32, done func(ret int, ent *LogEntry)) (err error) {
+	n.loop.exec(func() {
+		if n.client == nil {
+			err = ErrNoClient
+			return
+		}
+		err = n.client.Query(iid, done)
+	})
+	return
+}
+
+//EphemeralClientIDMin : IDs from here on are left to ephemeral clients,
+//servers have lower ones.
+const EphemeralClientIDMin uint32 = 1 << 24
+
+//EphemeralClientID : random ID for a client of one session; servers
+//need not know it. Calls start over from seq 1 with a new ID, so a
+//client which does not keep its state should take a new one each run.
+func EphemeralClientID() uint32 {
+	return EphemeralClientIDMin + uint32(rand.Int63n(int64(math.MaxUint32-EphemeralClientIDMin)+1))
+}
+
+//hasID : id is in lst.
+func hasID(lst []uint32, id uint32) bool {
+	for _, v := range lst {
+		if v == id {
+			return true
+		}
+	}
+	return false
+}
+
+//Roles : names of the protocol roles the node runs, once started.
+func (n *Node) Roles() (roles []string) {
+	n.loop.exec(func() {
+		if n.proposer != nil {
+			roles = append(roles, "proposer")
+		}
+		if n.acceptor != nil {
+			roles = append(roles, "acceptor")
+		}
+		if n.learner != nil {
+			roles = append(roles, "learner")
+		}
+		if n.client != nil {
+			roles = append(roles, "client")
+		}
+	})
+	return roles
+}
+
+//Stats : snapshot of node counters.
+func (n *Node) Stats() NodeStats {
+	return NodeStats{
+		CorruptFrames: atomic.LoadUint64(&n.stats.CorruptFrames),
+		AuthFailures:  atomic.LoadUint64(&n.stats.AuthFailures),
+		RecvWaits:     atomic.LoadUint64(&n.stats.RecvWaits),
+		CfgMismatches: atomic.LoadUint64(&n.stats.CfgMismatches),
+	}
+}
+
+//seal : frame data as sent by this node, if key is not nil;
+//data which is no pxs msg is sent as is.
+func (n *Node) seal(data, key []byte) ([]byte, error) {
+	if key == nil || len(data) < 2 || binary.LittleEndian.Uint16(data) != wire.PxsMsgMagic {
+		return data, nil
+	}
+	return wire.SealPxsMsg(data, n.id, key)
+}
+
+//ErrReloadUnsafe : a config differs from the one the node runs in more
+//than Tuning.
+var ErrReloadUnsafe = errors.New("config change needs a restart")
+
+//Reload : run with the Tuning of cfg from now on, timers armed already
+//keep theirs; the rest of cfg must be the one the node runs with.
+func (n *Node) Reload(cfg *config.ClusterConfig) (err error) {
+	if err = cfg.Validate(); err != nil {
+		return err
+	}
+	n.loop.exec(func() {
+		if n.cfg == nil || !n.cfg.Reloadable(cfg) {
+			err = ErrReloadUnsafe
+			return
+		}
+		n.cfg.Tuning = cfg.Tuning
+		n.tuning = cfg.Tuning.WithDefaults()
+		log.Printf("[%d]Reload - tuning:%+v\n", n.id, n.tuning)
+	})
+	return
+}
+
+//maxValueSize : largest client value this node takes.
+func (n *Node) maxValueSize() uint32 {
+	max := wire.DefaultMaxValueSize
+	if n.cfg != nil && n.cfg.MaxValueSize != 0 {
+		max = n.cfg.MaxValueSize
+	}
+	if max > wire.MaxValueSize {
+		max = wire.MaxValueSize
+	}
+	return max
+}
+
+//SendTo : remote node; safe from any goroutine.
+func (n *Node) SendTo(id uint32, data []byte) (int, error) {
+	key := n.secret()
+	frm, err := n.seal(data, key)
+	if err != nil {
+		return -1, err
+	}
+	max := n.trans.MaxMsgSize()
+	if max == 0 || len(frm) <= max {
+		nwr, err := n.trans.SendTo(id, frm)
+		if nwr == len(frm) {
+			nwr = len(data)
+		}
+		return nwr, err
+	}
+	//too large for one datagram, send it in pieces.
+	chunk := max - wire.PxsFragmentOverhead
+	if key != nil {
+		chunk -= wire.PxsMsgAuthSize
+	}
+	frgs, err := wire.FragmentPxsMsg(atomic.AddUint32(&n.fragID, 1), frm, chunk)
+	if err != nil {
+		return -1, err
+	}
+	for _, bs := range frgs {
+		if bs, err = n.seal(bs, key); err != nil {
+			return -1, err
+		}
+		nwr, err := n.trans.SendTo(id, bs)
+		if nwr != len(bs) {
+			if err == nil {
+				err = io.ErrShortWrite
+			}
+			return -1, err
+		}
+	}
+	return len(data), nil
+}
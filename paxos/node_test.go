@@ -0,0 +1,832 @@
+package paxos
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/wilem/simple-paxos/config"
+	"github.com/wilem/simple-paxos/storage"
+	"github.com/wilem/simple-paxos/transport"
+	"github.com/wilem/simple-paxos/wire"
+)
+
+//newMemCluster : servers 1..nsrv, all proposer, acceptor and learner,
+//plus client node 9, on one MemFabric.
+func newMemCluster(t *testing.T, fabric *transport.MemFabric, nsrv uint32) map[uint32]*Node {
+	nodes := make(map[uint32]*Node)
+	for _, id := range append(seqIDs(nsrv), 9) {
+		cfg := config.NewClusterConfig(id)
+		for _, sid := range seqIDs(nsrv) {
+			cfg.ServerList = append(cfg.ServerList, sid)
+			cfg.ProposerList = append(cfg.ProposerList, sid)
+			cfg.AcceptorList = append(cfg.AcceptorList, sid)
+			cfg.LearnerList = append(cfg.LearnerList, sid)
+		}
+		nodes[id] = NewNodeConfig(cfg, fabric.NewTransport(id))
+		if err := nodes[id].Start(); err != nil {
+			t.Fatal("Start:", err)
+		}
+	}
+	return nodes
+}
+
+//seqIDs : 1..n
+func seqIDs(n uint32) (ids []uint32) {
+	for id := uint32(1); id <= n; id++ {
+		ids = append(ids, id)
+	}
+	return
+}
+
+func Test2Nodes(t *testing.T) {
+	fabric := transport.NewMemFabric()
+	n1 := NewNodeConfig(loadConfig(t, "testdata/cluster.cfg", 1), fabric.NewTransport(1))
+	n2 := NewNodeConfig(loadConfig(t, "testdata/cluster.cfg", 2), fabric.NewTransport(2))
+	n1.Start()
+	n2.Start()
+	n, err := n1.SendTo(2, []byte("xxx,foo"))
+	if n == 0 || err != nil {
+		t.Errorf("n1.SendTo:%s\n", err)
+	}
+
+	n, err = n2.SendTo(1, []byte("xxx,bar"))
+	if n == 0 || err != nil {
+		t.Errorf("n2.SendTo:%s\n", err)
+	}
+	fabric.Drain(0)
+	//garbage dropped, hello went through.
+	if n1.bufMap[2].Len() != 0 || n2.bufMap[1].Len() != 0 {
+		t.Error("garbage left in buffers")
+	}
+	if !greeted(n1, 2) || !greeted(n2, 1) {
+		t.Error("hello not handled")
+	}
+}
+
+//greeted : n took a hello from peer id.
+func greeted(n *Node, id uint32) (ok bool) {
+	n.loop.exec(func() { ok = n.hello[id] })
+	return
+}
+
+//loadConfig : ClusterConfig of node id from the cluster file
+func loadConfig(t *testing.T, file string, id uint32) *config.ClusterConfig {
+	cfg, err := config.LoadClusterConfig(file, id)
+	if err != nil {
+		t.Fatal("LoadClusterConfig:", err)
+	}
+	return cfg
+}
+
+//TestNodeConfigMismatch : a server with another cluster config is left
+//out, its msgs dropped, until it says hello with the same config.
+func TestNodeConfigMismatch(t *testing.T) {
+	fabric := transport.NewMemFabric()
+	nodes := make(map[uint32]*Node)
+	for _, id := range []uint32{1, 2, 3, 9} {
+		cfg := loadConfig(t, "testdata/cluster.cfg", id)
+		if id == 3 { //a stale copy of the cluster file
+			cfg.Addrs = transport.AddrBook{3: "127.0.0.1:7003"}
+		}
+		nodes[id] = NewNodeConfig(cfg, fabric.NewTransport(id))
+		if err := nodes[id].Start(); err != nil {
+			t.Fatal("Start:", err)
+		}
+	}
+	fabric.Drain(0)
+	if !greeted(nodes[1], 2) || !greeted(nodes[1], 9) {
+		t.Error("same config: hello not taken")
+	}
+	if greeted(nodes[1], 3) || greeted(nodes[3], 2) {
+		t.Error("other config: hello taken")
+	}
+	if nodes[1].Stats().CfgMismatches == 0 || nodes[3].Stats().CfgMismatches == 0 {
+		t.Error("mismatches not counted:", nodes[1].Stats(), nodes[3].Stats())
+	}
+	ret := -1
+	if err := nodes[9].Do(&KVOp{Op: KVOpPut, Key: "k", Val: "v"}, func(r int, _ string) { ret = r }); err != nil {
+		t.Fatal("Do:", err)
+	}
+	fabric.Drain(0)
+	if ret != int(PxsStatusOK) {
+		t.Fatal("put: ret", ret)
+	}
+	var learned int
+	nodes[3].loop.exec(func() { learned = len(nodes[3].learner.chosen) })
+	if learned != 0 {
+		t.Error("node 3 learned from a cluster it is not part of:", learned)
+	}
+	//fixed config: taken back in.
+	nodes[3].loop.exec(func() {
+		nodes[3].cfgHash = nodes[1].cfgHash
+		nodes[3].sayHello()
+	})
+	fabric.Drain(0)
+	if !greeted(nodes[1], 3) || !greeted(nodes[3], 1) {
+		t.Error("same config again: hello not taken")
+	}
+}
+
+func TestNode(t *testing.T) {
+	fabric := transport.NewMemFabric()
+	nodes := newMemCluster(t, fabric, 3)
+	n9 := nodes[9]
+	fabric.Drain(0)
+
+	//client send multiple values.
+	var seq uint32
+	const rnd = 10
+	for {
+		seq++
+		var val wire.Value
+		val.Siz = 4
+		val.Oct = make([]byte, 4)
+		val.Oct[0] = byte(seq % 255)
+		ret, err := n9.client.Submit(seq, &val)
+		if err != nil {
+			log.Println("Submit failed - ret,err =", ret, err)
+		}
+		fabric.Drain(0)
+		if seq == rnd {
+			break
+		}
+	}
+
+	//every acceptor took every value, in order.
+	for _, id := range seqIDs(3) {
+		acc := nodes[id].acceptor
+		for iid := uint32(1); iid <= rnd; iid++ {
+			if v := acc.maxVal[iid]; v == nil || v.Oct[0] != byte(iid) {
+				t.Errorf("acceptor %d, iid %d: val:%+v\n", id, iid, v)
+			}
+		}
+	}
+}
+
+func TestMemCluster(t *testing.T) {
+	for nsrv := uint32(3); nsrv <= 7; nsrv += 2 {
+		fabric := transport.NewMemFabric()
+		nodes := newMemCluster(t, fabric, nsrv)
+		fabric.Drain(0)
+		val := wire.NewValue([]byte{byte(nsrv), 4, 2})
+		if _, err := nodes[9].client.Submit(1, val); err != nil {
+			t.Fatal("Submit:", err)
+		}
+		//one msg at a time: request, then prepare to all acceptors...
+		if !fabric.Step() || nodes[1].proposer.phase[1] != pxsPhaseSendPrepare {
+			t.Fatal("request not handled")
+		}
+		fabric.Drain(0)
+		var nacc uint32
+		for _, id := range seqIDs(nsrv) {
+			if v := nodes[id].acceptor.maxVal[1]; v != nil && bytes.Equal(v.Oct, val.Oct) {
+				nacc++
+			}
+		}
+		if nacc != nsrv || nodes[1].proposer.phase[1] != pxsPhaseCommitted {
+			t.Errorf("%d nodes - accepted by:%d, phase:%d\n", nsrv, nacc, nodes[1].proposer.phase[1])
+		}
+	}
+}
+
+func TestNodeMaxValueSize(t *testing.T) {
+	n := NewNodeTransport(9, transport.NewMemFabric().NewTransport(9))
+	n.cfg = config.NewClusterConfig(9)
+	n.cfg.MaxValueSize = 8
+	n.client = &Client{node: n}
+	n.proposer = NewProposer(n)
+	val := wire.NewValue(make([]byte, 9))
+	ret, err := n.client.Submit(1, val)
+	if ret != int(PxsStatusValueTooLarge) || !errors.Is(err, wire.ErrValueTooLarge) {
+		t.Error("Submit: ret,err =", ret, err)
+	}
+	ret = n.proposer.OnRecvRequest(wire.NewPxsMsgRequest(1, val), 8)
+	if ret != int(PxsStatusValueTooLarge) || len(n.proposer.pendingList) != 0 {
+		t.Error("OnRecvRequest: ret =", ret)
+	}
+}
+
+func TestNodeLargeValue(t *testing.T) {
+	n := NewNodeTransport(2, transport.NewMemFabric().NewTransport(2))
+	n.cfg = config.NewClusterConfig(2)
+	n.acceptor = NewAcceptor(n)
+	val := wire.NewValue(make([]byte, 200*1024))
+	frm, _ := wire.NewPxsMsgAccept(1, 101, val).Encode()
+	frgs, _ := wire.FragmentPxsMsg(1, frm, 0)
+	for i, bs := range frgs {
+		if n.acceptor.maxVal[1] != nil {
+			t.Fatal("value accepted with", i, "of", len(frgs), "pieces")
+		}
+		n.OnRecv(1, bs)
+	}
+	if v := n.acceptor.maxVal[1]; v == nil || !bytes.Equal(v.Oct, val.Oct) {
+		t.Fatal("large value not accepted")
+	}
+}
+
+func TestNodeAuth(t *testing.T) {
+	fabric := transport.NewMemFabric()
+	fabric.MaxMsgSize = 1024
+	var nodes []*Node
+	for _, id := range []uint32{1, 2, 3} {
+		cfg := config.NewClusterConfig(id)
+		cfg.ServerList = []uint32{1, 2}
+		cfg.AcceptorList = []uint32{1, 2}
+		cfg.Secret = "s3cret"
+		n := NewNodeConfig(cfg, fabric.NewTransport(id))
+		if err := n.Start(); err != nil {
+			t.Fatal("Start:", err)
+		}
+		nodes = append(nodes, n)
+	}
+	n1, n2, n3 := nodes[0], nodes[1], nodes[2]
+	fabric.Drain(0)
+	//1. sealed, in pieces too
+	val := wire.NewValue(make([]byte, 4096))
+	frm, _ := wire.NewPxsMsgAccept(1, 101, val).Encode()
+	if nwr, err := n1.SendTo(2, frm); nwr != len(frm) || err != nil {
+		t.Fatal("SendTo: nwr,err =", nwr, err)
+	}
+	fabric.Drain(0)
+	if v := n2.acceptor.maxVal[1]; v == nil || !bytes.Equal(v.Oct, val.Oct) {
+		t.Fatal("sealed value not accepted")
+	}
+	//2. unsealed, sealed with another key, sealed by another node
+	small, _ := wire.NewPxsMsgAccept(2, 101, wire.NewValue([]byte{1})).Encode()
+	forged, _ := wire.SealPxsMsg(small, 3, []byte("guess"))
+	relayed, _ := wire.SealPxsMsg(small, 1, []byte("s3cret"))
+	n3.trans.SendTo(2, small)
+	n3.trans.SendTo(2, forged)
+	n3.trans.SendTo(2, relayed)
+	fabric.Drain(0)
+	if n2.acceptor.maxVal[2] != nil {
+		t.Error("unauthenticated value accepted")
+	}
+	if st := n2.Stats(); st.AuthFailures != 3 || st.CorruptFrames != 0 {
+		t.Errorf("stats: %+v\n", st)
+	}
+}
+
+//TestNodeStopStart : a node restarted recovers its acceptor and learner
+//state; a cluster restarted as a whole still serves what was chosen.
+func TestNodeStopStart(t *testing.T) {
+	fabric := transport.NewMemFabric()
+	nodes := newMemCluster(t, fabric, 3)
+	fabric.Drain(0)
+	do := func(op *KVOp) (ret int, res string) {
+		ret = -1
+		if err := nodes[9].Do(op, func(r int, s string) { ret, res = r, s }); err != nil {
+			t.Fatal("Do:", err)
+		}
+		fabric.Drain(0)
+		return
+	}
+	if ret, _ := do(&KVOp{Op: KVOpPut, Key: "k", Val: "v1"}); ret != 0 {
+		t.Fatal("put: ret =", ret)
+	}
+	n2 := nodes[2]
+	acc, chosen := n2.acceptor, n2.learner.chosen
+	if err := n2.Stop(); err != nil {
+		t.Fatal("Stop:", err)
+	}
+	if err := n2.Start(); err != nil {
+		t.Fatal("Start:", err)
+	}
+	if n2.acceptor == acc || !reflect.DeepEqual(n2.acceptor.maxVal, acc.maxVal) ||
+		!reflect.DeepEqual(n2.acceptor.maxBal, acc.maxBal) || !reflect.DeepEqual(n2.learner.chosen, chosen) {
+		t.Fatal("node 2 state not recovered")
+	}
+	if v, _ := n2.rsm.(*KVStore).Get("k"); v != "v1" {
+		t.Error("node 2 state machine not rebuilt: k =", v)
+	}
+	//whole cluster, a call in flight
+	if err := nodes[9].Do(&KVOp{Op: KVOpPut, Key: "k", Val: "v2"}, func(r int, s string) {
+		if r != int(PxsStatusClusterUnavailable) {
+			t.Error("call in flight: ret =", r)
+		}
+	}); err != nil {
+		t.Fatal("Do:", err)
+	}
+	for _, n := range nodes {
+		if err := n.Stop(); err != nil {
+			t.Fatal("Stop:", err)
+		}
+	}
+	if fabric.Drain(0); nodes[9].client.call != nil {
+		t.Fatal("call still in flight")
+	}
+	for _, id := range append(seqIDs(3), 9) {
+		if err := nodes[id].Start(); err != nil {
+			t.Fatal("Start:", err)
+		}
+	}
+	fabric.Drain(0)
+	if ret, res := do(&KVOp{Op: KVOpGet, Key: "k"}); ret != 0 || res != "v1" {
+		t.Error("get after restart: ret,res =", ret, res)
+	}
+}
+
+//failStorage : Storage whose Save and Append fail.
+type failStorage struct{ storage.MemStorage }
+
+func (*failStorage) Save(*storage.NodeState) error { return errors.New("disk full") }
+func (*failStorage) Append([]storage.Record) error { return errors.New("disk full") }
+
+//TestNodeAcceptorDurable : an acceptor saves its promise and vote before
+//it answers; one which can't save doesn't answer.
+func TestNodeAcceptorDurable(t *testing.T) {
+	fabric := transport.NewMemFabric()
+	nodes := newMemCluster(t, fabric, 3)
+	fabric.Drain(0)
+	if err := nodes[9].Do(&KVOp{Op: KVOpPut, Key: "k", Val: "v1"}, func(int, string) {}); err != nil {
+		t.Fatal("Do:", err)
+	}
+	fabric.Drain(0)
+	n2 := nodes[2]
+	st, err := n2.store.Load() //crashed now, without Stop
+	if err != nil || st == nil || len(st.Acceptor) != 1 ||
+		st.Acceptor[0].Bal != n2.acceptor.maxBal[1] || string(st.Acceptor[0].Val) != string(n2.acceptor.maxVal[1].Oct) {
+		t.Fatalf("acceptor state not saved: %+v %v", st, err)
+	}
+	bs, _ := wire.NewPxsMsgPrepare(7, 0x10001).Encode()
+	n2.OnRecv(1, bs)
+	if fabric.Pending() != 1 {
+		t.Fatal("no promise:", fabric.Pending())
+	}
+	fabric.Drain(0)
+	n2.store = new(failStorage)
+	bs, _ = wire.NewPxsMsgPrepare(8, 0x10001).Encode()
+	n2.OnRecv(1, bs)
+	bs, _ = wire.NewPxsMsgAccept(7, 0x10001, wire.NewValue([]byte("v"))).Encode()
+	n2.OnRecv(1, bs)
+	if fabric.Pending() != 0 {
+		t.Error("answered with state not saved:", fabric.Pending())
+	}
+}
+
+//countStorage : MemStorage counting its snapshots.
+type countStorage struct {
+	storage.MemStorage
+	saves int
+}
+
+func (s *countStorage) Save(st *storage.NodeState) error {
+	s.saves++
+	return s.MemStorage.Save(st)
+}
+
+//TestNodeLogCompacted : an acceptor appends a record per vote, a client
+//per call before it is sent; snapshots replace the log now and then.
+func TestNodeLogCompacted(t *testing.T) {
+	fabric := transport.NewMemFabric()
+	nodes := newMemCluster(t, fabric, 3)
+	store := new(countStorage)
+	nodes[2].store = store
+	fabric.Drain(0)
+	for i := 0; i < 200; i++ {
+		if err := nodes[9].Do(&KVOp{Op: KVOpPut, Key: "k", Val: fmt.Sprint(i)}, func(int, string) {}); err != nil {
+			t.Fatal("Do:", err)
+		}
+		if i == 0 { //crashed now, before an answer
+			if st, err := nodes[9].store.Load(); err != nil || st == nil || st.ClientSeq != 1 {
+				t.Fatalf("client seq not saved: %+v %v", st, err)
+			}
+		}
+		fabric.Drain(0)
+	}
+	//the last value chosen is saved with the next record.
+	if st, err := store.Load(); err != nil || len(st.Acceptor) != 200 || len(st.Chosen) != 199 {
+		t.Fatalf("acceptor state: %d %d %v", len(st.Acceptor), len(st.Chosen), err)
+	}
+	//600 records: a snapshot each time the log grew as large as the last one.
+	if store.saves == 0 || store.saves > 8 {
+		t.Error("snapshots:", store.saves)
+	}
+}
+
+//TestNodeQuery : client reads back the log from learners.
+func TestNodeQuery(t *testing.T) {
+	fabric := transport.NewMemFabric()
+	nodes := newMemCluster(t, fabric, 3)
+	fabric.Drain(0)
+	if err := nodes[9].Do(&KVOp{Op: KVOpPut, Key: "k", Val: "v"}, func(int, string) {}); err != nil {
+		t.Fatal("Do:", err)
+	}
+	fabric.Drain(0)
+	query := func(iid uint32) (ret int, ent *LogEntry) {
+		ret = -1
+		if err := nodes[9].Query(iid, func(r int, e *LogEntry) { ret, ent = r, e }); err != nil {
+			t.Fatal("Query:", err)
+		}
+		fabric.Drain(0)
+		return
+	}
+	ret, ent := query(1)
+	if ret != int(PxsStatusOK) || ent == nil || ent.Val == nil || ent.Next != 2 || ent.Leader != 1 {
+		t.Fatalf("iid 1: ret:%d, ent:%+v", ret, ent)
+	}
+	if op, err := DecodeKVOp(ent.Val); err != nil || op.Key != "k" || op.Val != "v" {
+		t.Error("iid 1: op,err =", op, err)
+	}
+	if ret, ent = query(2); ret != int(PxsStatusNotChosen) || ent.Val != nil || ent.Next != 2 {
+		t.Errorf("iid 2: ret:%d, ent:%+v", ret, ent)
+	}
+	//one at a time; ended by Stop.
+	nodes[9].Query(0, func(r int, _ *LogEntry) { ret = r })
+	if err := nodes[9].Query(0, nil); !errors.Is(err, ErrClientBusy) {
+		t.Error("2nd query:", err)
+	}
+	if nodes[9].Stop(); ret != int(PxsStatusClusterUnavailable) {
+		t.Error("query in flight: ret =", ret)
+	}
+	if err := nodes[1].Query(1, nil); !errors.Is(err, ErrNoClient) {
+		t.Error("query on server:", err)
+	}
+}
+
+//TestNodeClients : nodes outside ServerList and the ones in ClientList
+//are clients, with any ID; servers answer them unlisted.
+func TestNodeClients(t *testing.T) {
+	fabric := transport.NewMemFabric()
+	nodes := make(map[uint32]*Node)
+	eid := EphemeralClientID()
+	if eid < EphemeralClientIDMin {
+		t.Fatal("ephemeral ID:", eid)
+	}
+	for _, id := range []uint32{1, 2, 3, 4, 9, eid} {
+		cfg := config.NewClusterConfig(id)
+		cfg.ServerList = seqIDs(4) //4: server with a client only
+		cfg.ProposerList = seqIDs(3)
+		cfg.AcceptorList = seqIDs(3)
+		cfg.LearnerList = seqIDs(3)
+		cfg.ClientList = []uint32{4}
+		nodes[id] = NewNodeConfig(cfg, fabric.NewTransport(id))
+		if err := nodes[id].Start(); err != nil {
+			t.Fatal("Start:", err)
+		}
+	}
+	fabric.Drain(0)
+	for id, n := range nodes {
+		if want := id > 3; (n.client != nil) != want {
+			t.Errorf("node %d: client:%v", id, n.client != nil)
+		}
+	}
+	for i, id := range []uint32{9, 4, eid} {
+		ret, res := -1, ""
+		op := &KVOp{Op: KVOpPut, Key: "k", Val: fmt.Sprint(i)}
+		if err := nodes[id].Do(op, func(r int, s string) { ret, res = r, s }); err != nil {
+			t.Fatal("Do:", err)
+		}
+		fabric.Drain(0)
+		if ret != 0 {
+			t.Errorf("client %d: ret,res = %d,%q", id, ret, res)
+		}
+	}
+	if v, _ := nodes[1].rsm.(*KVStore).Get("k"); v != "2" {
+		t.Error("k =", v)
+	}
+}
+
+//freeUDPAddrs : n distinct local addresses nobody listens on, for now.
+func freeUDPAddrs(t *testing.T, n int) []string {
+	var addrs []string
+	for i := 0; i < n; i++ {
+		conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.ParseIP(transport.LocalIPAddr)})
+		if err != nil {
+			t.Fatal("ListenUDP:", err)
+		}
+		defer conn.Close()
+		addrs = append(addrs, conn.LocalAddr().String())
+	}
+	return addrs
+}
+
+func TestNodeDataDir(t *testing.T) {
+	cfg := config.NewClusterConfig(141)
+	cfg.ServerList = []uint32{141}
+	cfg.AcceptorList = []uint32{141}
+	cfg.DataDir = t.TempDir()
+	cfg.Addrs = transport.AddrBook{141: freeUDPAddrs(t, 1)[0]}
+	for i := uint32(1); i <= 3; i++ {
+		n := NewNodeConfig(cfg, transport.NewUDPTransportAddrs(141, cfg.Addrs))
+		if err := n.Start(); err != nil {
+			t.Fatal("Start:", err)
+		}
+		if n.acceptor.maxBal[1] != i-1 {
+			t.Fatal("promise not recovered:", n.acceptor.maxBal[1])
+		}
+		bs, _ := wire.NewPxsMsgPrepare(1, i).Encode()
+		n.OnRecv(1, bs)
+		if err := n.Stop(); err != nil {
+			t.Fatal("Stop:", err)
+		}
+	}
+}
+
+//TestNodeConcurrent : API calls, msgs and restarts from many goroutines
+//on a UDP cluster, whose nodes run their own event loop; run with -race.
+func TestNodeConcurrent(t *testing.T) {
+	ids := append(seqIDs(3), 9)
+	free := freeUDPAddrs(t, len(ids))
+	book := transport.AddrBook{}
+	for i, id := range ids {
+		book[id] = free[i]
+	}
+	nodes := make(map[uint32]*Node)
+	for _, id := range ids {
+		cfg := config.NewClusterConfig(id)
+		cfg.ServerList = seqIDs(3)
+		cfg.ProposerList = seqIDs(3)
+		cfg.AcceptorList = seqIDs(3)
+		cfg.LearnerList = seqIDs(3)
+		cfg.Addrs = book
+		nodes[id] = NewNodeConfig(cfg, transport.NewUDPTransportAddrs(id, book))
+		if err := nodes[id].Start(); err != nil {
+			t.Fatal("Start:", err)
+		}
+		defer nodes[id].Stop()
+	}
+	n9 := nodes[9]
+	do := func(op *KVOp) (int, string) {
+		type result struct {
+			ret int
+			res string
+		}
+		ch := make(chan result, 1)
+		for {
+			err := n9.Do(op, func(ret int, res string) { ch <- result{ret, res} })
+			if err == nil {
+				break
+			}
+			if !errors.Is(err, ErrClientBusy) {
+				t.Error("Do:", err)
+				return -1, ""
+			}
+			time.Sleep(time.Millisecond)
+		}
+		select {
+		case r := <-ch:
+			return r.ret, r.res
+		case <-time.After(10 * time.Second):
+			t.Error("call not answered:", op)
+			return -1, ""
+		}
+	}
+	const nwrk, nop = 4, 10
+	var wg sync.WaitGroup
+	for w := 0; w < nwrk; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for k := 0; k < nop; k++ {
+				op := &KVOp{Op: KVOpPut, Key: fmt.Sprint("k", w), Val: fmt.Sprint(k)}
+				if ret, _ := do(op); ret != 0 {
+					t.Error("put: ret =", ret)
+				}
+			}
+		}(w)
+	}
+	stop := make(chan struct{})
+	var noise sync.WaitGroup
+	noise.Add(2)
+	go func() { //garbage, stats and queries
+		defer noise.Done()
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			for _, n := range nodes {
+				n.OnRecv(77, []byte("xxx,foo"))
+				greeted(n, 1)
+				n.Stats()
+			}
+			n9.SendTo(2, []byte("xxx,bar"))
+			time.Sleep(time.Millisecond)
+		}
+	}()
+	go func() { //a server restarted
+		defer noise.Done()
+		for i := 0; i < 3; i++ {
+			time.Sleep(20 * time.Millisecond)
+			if err := nodes[3].Stop(); err != nil {
+				t.Error("Stop:", err)
+			}
+			if err := nodes[3].Start(); err != nil {
+				t.Error("Start:", err)
+			}
+		}
+	}()
+	wg.Wait()
+	close(stop)
+	noise.Wait()
+	for w := 0; w < nwrk; w++ {
+		if ret, res := do(&KVOp{Op: KVOpGet, Key: fmt.Sprint("k", w)}); ret != 0 || res != fmt.Sprint(nop-1) {
+			t.Errorf("get k%d: ret,res = %d %q\n", w, ret, res)
+		}
+	}
+}
+
+//TestNodeBackpressure : with RecvQueueSize msgs waiting for a busy event
+//loop, the transport waits; none is lost.
+func TestNodeBackpressure(t *testing.T) {
+	cfg := config.NewClusterConfig(161)
+	cfg.Addrs = transport.AddrBook{161: freeUDPAddrs(t, 1)[0]}
+	n := NewNodeConfig(cfg, transport.NewUDPTransportAddrs(161, cfg.Addrs))
+	if err := n.Start(); err != nil {
+		t.Fatal("Start:", err)
+	}
+	defer n.Stop()
+	block := make(chan struct{})
+	n.loop.post(func() { <-block })
+	hlo, _ := wire.NewPxsMsgHello(0).Encode()
+	done := make(chan struct{})
+	go func() { //a transport delivering
+		defer close(done)
+		for i := uint32(0); i <= RecvQueueSize; i++ {
+			n.OnRecv(1000+i, append([]byte(nil), hlo...))
+		}
+	}()
+	for i := 0; n.Stats().RecvWaits == 0; i++ {
+		if i == 200 {
+			t.Fatal("transport never waited")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	select {
+	case <-done:
+		t.Fatal("transport not held back")
+	default:
+	}
+	close(block)
+	<-done
+	if !greeted(n, 1000+RecvQueueSize) {
+		t.Error("last msg not handled")
+	}
+}
+
+func TestNodeCorruptFrameCounter(t *testing.T) {
+	n := NewNodeTransport(1, transport.NewMemFabric().NewTransport(1))
+	bs, _ := wire.NewPxsMsgCommit(1, 101, &wire.Value{}).Encode()
+	bs[wire.PxsMsgHeaderSize] ^= 0xFF //corrupt ballot
+	n.OnRecv(2, bs)
+	if st := n.Stats(); st.CorruptFrames != 1 {
+		t.Error("CorruptFrames:", st.CorruptFrames)
+	}
+}
+
+//TestNodeFrameTooLarge : a header claiming a huge frame resets the
+//stream of the peer, the next frame is handled.
+func TestNodeFrameTooLarge(t *testing.T) {
+	n := NewNodeTransport(1, transport.NewMemFabric().NewTransport(1))
+	hdr, _ := wire.NewPxsMsgCommit(1, 101, &wire.Value{}).Encode()
+	binary.LittleEndian.PutUint32(hdr[4:], 0x7fffffff) //siz
+	n.OnRecv(2, hdr[:wire.PxsMsgHeaderSize])
+	bs, _ := wire.NewPxsMsgHello(0).Encode()
+	n.OnRecv(2, bs)
+	if !greeted(n, 2) {
+		t.Error("hello after oversized frame not handled")
+	}
+}
+
+//TestNodeLatePromise : promises late for an iid another proposer got
+//chosen start no phase 2 for it, with the next value or with none.
+func TestNodeLatePromise(t *testing.T) {
+	fabric := transport.NewMemFabric() //not drained: node 1 only gets the msgs below
+	n := newMemCluster(t, fabric, 3)[1]
+	a, b := wire.NewValue([]byte("a")), wire.NewValue([]byte("b"))
+	recv := func(from uint32, m interface{ Encode() ([]byte, error) }) {
+		bs, _ := m.Encode()
+		n.OnRecv(from, bs)
+	}
+	const b11, b12 = 1<<16 | 1, 1<<16 | 2
+	recv(9, wire.NewPxsMsgRequest(1, a))
+	recv(2, wire.NewPxsMsgCommit(1, b12, b)) //a goes on with iid 2
+	recv(2, wire.NewPxsMsgPromise(1, 2, b11, wire.Invalidballot, &wire.Value{}))
+	recv(3, wire.NewPxsMsgPromise(1, 3, b11, wire.Invalidballot, &wire.Value{}))
+	if len(n.proposer.p2a) != 0 {
+		t.Fatal("accept sent for iid 1 chosen:", n.proposer.p2a)
+	}
+	recv(2, wire.NewPxsMsgCommit(2, b12, a)) //no value left
+	recv(2, wire.NewPxsMsgPromise(2, 2, b11, wire.Invalidballot, &wire.Value{}))
+	recv(3, wire.NewPxsMsgPromise(2, 3, b11, wire.Invalidballot, &wire.Value{}))
+	if len(n.proposer.p2a) != 0 || n.proposer.phase[2] != pxsPhaseCommitted {
+		t.Error("accept sent for iid 2 chosen:", n.proposer.p2a, n.proposer.phase)
+	}
+}
+
+//TestNodeCallNotACall : a value which is no call of the client is
+//refused, it would never be answered.
+func TestNodeCallNotACall(t *testing.T) {
+	fabric := transport.NewMemFabric()
+	nodes := newMemCluster(t, fabric, 3)
+	fabric.Drain(0)
+	for _, val := range []*wire.Value{
+		wire.NewValue([]byte("raw value")),
+		(&KVOp{Cli: 8, Seq: 1, Op: KVOpPut, Key: "k"}).Value(),
+		(&KVOp{Cli: 9, Seq: 2, Op: KVOpPut, Key: "k"}).Value(),
+	} {
+		if _, err := nodes[9].Call(val, nil); !errors.Is(err, ErrNotACall) {
+			t.Errorf("Call %q: err = %v", val.Oct, err)
+		}
+	}
+	ret := -1
+	if _, err := nodes[9].Call((&KVOp{Cli: 9, Seq: 1, Op: KVOpPut, Key: "k"}).Value(),
+		func(r int, _ *wire.Value) { ret = r }); err != nil {
+		t.Fatal("Call:", err)
+	}
+	fabric.Drain(0)
+	if ret != int(PxsStatusOK) || len(nodes[1].proposer.waiting) != 0 {
+		t.Error("call not answered:", ret, nodes[1].proposer.waiting)
+	}
+}
+
+//TestNewNodeCluster : node on the transport of its config, roles from
+//the lists, state flushed to DataDir on Stop; bad configs and addresses
+//are errors.
+func TestNewNodeCluster(t *testing.T) {
+	cfg := config.NewClusterConfig(171)
+	cfg.ServerList = []uint32{171}
+	cfg.ProposerList = []uint32{171}
+	cfg.AcceptorList = []uint32{171}
+	cfg.DataDir = t.TempDir()
+	cfg.Addrs = transport.AddrBook{171: freeUDPAddrs(t, 1)[0]}
+	n, err := NewNodeCluster(cfg)
+	if err != nil {
+		t.Fatal("NewNodeCluster:", err)
+	}
+	if _, ok := n.trans.(*transport.UDPTransport); !ok {
+		t.Fatalf("transport: %T", n.trans)
+	}
+	if err := n.Start(); err != nil {
+		t.Fatal("Start:", err)
+	}
+	if roles := n.Roles(); !reflect.DeepEqual(roles, []string{"proposer", "acceptor"}) {
+		t.Error("roles:", roles)
+	}
+	//address taken: an error, not a panic.
+	n2, _ := NewNodeCluster(cfg)
+	if err := n2.Start(); err == nil {
+		t.Error("2nd node on the same address started")
+	}
+	if err := n.Stop(); err != nil {
+		t.Fatal("Stop:", err)
+	}
+	if _, err := os.Stat(filepath.Join(cfg.DataDir, "node171.state")); err != nil {
+		t.Error("state not flushed:", err)
+	}
+
+	cfg.AcceptorList = nil
+	if _, err := NewNodeCluster(cfg); !errors.Is(err, config.ErrInvalidConfig) {
+		t.Error("invalid config:", err)
+	}
+	if _, err := NewNodeLoad(filepath.Join(cfg.DataDir, "none.cfg")); !errors.Is(err, os.ErrNotExist) {
+		t.Error("missing config file:", err)
+	}
+}
+
+//TestNodeReload : a running node takes new tuning; other changes and
+//bad configs are refused.
+func TestNodeReload(t *testing.T) {
+	fabric := transport.NewMemFabric()
+	cfg := loadConfig(t, "testdata/cluster.cfg", 1)
+	n := NewNodeConfig(cfg, fabric.NewTransport(1))
+	if err := n.Start(); err != nil {
+		t.Fatal("Start:", err)
+	}
+	defer n.Stop()
+	if n.tuning != config.DefaultTuning {
+		t.Error("default tuning:", n.tuning)
+	}
+	newCfg := loadConfig(t, "testdata/cluster.cfg", 1)
+	newCfg.RetryTimeout = config.Duration(time.Second)
+	if err := n.Reload(newCfg); err != nil {
+		t.Fatal("Reload:", err)
+	}
+	if n.tuning.RetryTimeout != newCfg.RetryTimeout || n.tuning.ClientTimeout != config.DefaultTuning.ClientTimeout {
+		t.Error("tuning:", n.tuning)
+	}
+	newCfg.LearnerList = []uint32{1}
+	if err := n.Reload(newCfg); !errors.Is(err, ErrReloadUnsafe) {
+		t.Error("LearnerList change:", err)
+	}
+	newCfg.LearnerList = cfg.LearnerList
+	newCfg.ClientTimeout = config.Duration(-time.Minute)
+	if err := n.Reload(newCfg); !errors.Is(err, config.ErrInvalidConfig) {
+		t.Error("invalid tuning:", err)
+	}
+	if n.tuning.ClientTimeout != config.DefaultTuning.ClientTimeout {
+		t.Error("invalid tuning taken:", n.tuning)
+	}
+}
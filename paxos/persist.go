@@ -0,0 +1,119 @@
+package paxos
+
+import (
+	"log"
+	"sort"
+
+	"github.com/wilem/simple-paxos/storage"
+	"github.com/wilem/simple-paxos/wire"
+)
+
+//snapshot : persistent state of node n.
+func (n *Node) snapshot() *storage.NodeState {
+	st := &storage.NodeState{InstanceID: n.instanceID}
+	if n.client != nil {
+		st.ClientSeq = n.client.seq
+	}
+	if a := n.acceptor; a != nil {
+		for iid, bal := range a.maxBal {
+			rec := storage.AcceptorRecord{IID: iid, Bal: bal, VBal: a.maxVBal[iid]}
+			if v := a.maxVal[iid]; v != nil {
+				rec.Val = v.Oct
+			}
+			st.Acceptor = append(st.Acceptor, rec)
+		}
+		sort.Slice(st.Acceptor, func(i, j int) bool { return st.Acceptor[i].IID < st.Acceptor[j].IID })
+	}
+	if l := n.learner; l != nil {
+		for iid, v := range l.chosen {
+			st.Chosen = append(st.Chosen, storage.ChosenRecord{IID: iid, Val: v.Oct})
+		}
+		sort.Slice(st.Chosen, func(i, j int) bool { return st.Chosen[i].IID < st.Chosen[j].IID })
+	}
+	return st
+}
+
+//minSnapshotRecords : records appended at least before a snapshot.
+const minSnapshotRecords = 64
+
+//persist : save a snapshot of the state of node n, the log is emptied.
+func (n *Node) persist() error {
+	st := n.snapshot()
+	if err := n.store.Save(st); err != nil {
+		log.Printf("[%d]Save state FAILED - err:%s\n", n.id, err)
+		return err
+	}
+	n.unsaved, n.logged = nil, 0
+	n.snapSize = len(st.Acceptor) + len(st.Chosen)
+	return nil
+}
+
+//logRecords : append recs to the log of node n, with the values chosen
+//since the last append; an acceptor does before it answers a proposer,
+//a client before it sends a call, what they told must survive a crash.
+//Once the log holds as many records as the last snapshot, a new one
+//replaces both, so a record costs O(1) on average.
+func (n *Node) logRecords(recs ...storage.Record) error {
+	recs = append(n.unsaved, recs...)
+	if err := n.store.Append(recs); err != nil {
+		log.Printf("[%d]Append state FAILED - err:%s\n", n.id, err)
+		return err
+	}
+	n.unsaved = nil
+	if n.logged += len(recs); n.logged >= n.snapSize && n.logged >= minSnapshotRecords {
+		n.persist()
+	}
+	return nil
+}
+
+//logChosen : val chosen for iid is saved with the next records logged;
+//a learner can learn it again from its peers.
+func (n *Node) logChosen(iid uint32, val *wire.Value) {
+	n.unsaved = append(n.unsaved, storage.Record{Chosen: &storage.ChosenRecord{IID: iid, Val: val.Oct}})
+	if len(n.unsaved) >= minSnapshotRecords {
+		n.logRecords()
+	}
+}
+
+//record : of iid, as acceptor a holds it.
+func (a *Acceptor) record(iid uint32) storage.Record {
+	rec := &storage.AcceptorRecord{IID: iid, Bal: a.maxBal[iid], VBal: a.maxVBal[iid]}
+	if v := a.maxVal[iid]; v != nil {
+		rec.Val = v.Oct
+	}
+	return storage.Record{InstanceID: a.node.instanceID, Acceptor: rec}
+}
+
+//restore : node n picks up state st, roles already created.
+func (n *Node) restore(st *storage.NodeState) {
+	n.unsaved, n.logged = nil, 0
+	n.snapSize = len(st.Acceptor) + len(st.Chosen)
+	if st.InstanceID > n.instanceID {
+		n.instanceID = st.InstanceID
+	}
+	if n.client != nil {
+		n.client.seq = st.ClientSeq
+	}
+	if n.acceptor == nil && len(st.Acceptor) > 0 { //by a member op
+		n.acceptor = NewAcceptor(n)
+	}
+	if a := n.acceptor; a != nil {
+		for _, rec := range st.Acceptor {
+			a.maxBal[rec.IID] = rec.Bal
+			a.maxVBal[rec.IID] = rec.VBal
+			if rec.Val != nil {
+				a.maxVal[rec.IID] = wire.NewValue(rec.Val)
+			}
+		}
+	}
+	if n.learner == nil && len(st.Chosen) > 0 { //by a member op
+		n.rsm = NewKVStore()
+		n.learner = NewLearner(n)
+	}
+	if l := n.learner; l != nil {
+		for _, rec := range st.Chosen {
+			l.chosen[rec.IID] = wire.NewValue(rec.Val)
+		}
+		l.apply()
+	}
+}
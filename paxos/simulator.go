@@ -0,0 +1,303 @@
+package paxos
+
+import (
+	"container/heap"
+	"encoding/binary"
+	"hash"
+	"hash/fnv"
+	"math/rand"
+	"sort"
+	"time"
+
+	"github.com/wilem/simple-paxos/config"
+	"github.com/wilem/simple-paxos/transport"
+)
+
+// SimFaults : network model of a Sim, rates are in [0,1].
+type SimFaults struct {
+	//every msg takes a random time in [MinLatency, MaxLatency].
+	MinLatency, MaxLatency time.Duration
+	Drop                   float64 //msg is lost
+	Dup                    float64 //msg is delivered twice
+	//every PartitionEvery the network is split in two random sides
+	//with rate Partition, healed otherwise; 0: never.
+	PartitionEvery time.Duration
+	Partition      float64
+}
+
+// SimStats : counters of a Sim.
+type SimStats struct {
+	Events, Delivered, Dropped, Duplicated, Cut int
+}
+
+//simEvent : msg delivery or timer, due at virtual time at.
+type simEvent struct {
+	at   time.Duration
+	seq  uint64 //ties broken by schedule order.
+	fn   func()
+	done bool //ran or stopped.
+}
+
+//Stop : Timer of a Sim.
+func (e *simEvent) Stop() bool {
+	if e.done {
+		return false
+	}
+	e.done = true
+	return true
+}
+
+type simEventHeap []*simEvent
+
+func (h simEventHeap) Len() int { return len(h) }
+func (h simEventHeap) Less(i, j int) bool {
+	if h[i].at != h[j].at {
+		return h[i].at < h[j].at
+	}
+	return h[i].seq < h[j].seq
+}
+func (h simEventHeap) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+func (h *simEventHeap) Push(x interface{}) {
+	*h = append(*h, x.(*simEvent))
+}
+func (h *simEventHeap) Pop() interface{} {
+	old := *h
+	e := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return e
+}
+
+// Sim : discrete-event simulation of a cluster in one goroutine. Msgs
+// and timers of all nodes are events on one virtual clock; latency,
+// loss, dups and partitions, the latter as a transport.FaultModel does
+// them for a NetSim, all come from one seeded PRNG. Given the
+// same seed and the same inputs a run is repeated bit for bit, which
+// Digest tells.
+type Sim struct {
+	*transport.FaultModel
+	Faults SimFaults
+	Stats  SimStats
+	//Tap : sees every msg sent, before the network acts on it.
+	Tap     func(at time.Duration, src, dst uint32, data []byte)
+	rng     *rand.Rand
+	now     time.Duration //virtual time since start.
+	seq     uint64
+	events  simEventHeap
+	nodeMap map[uint32]*Node
+	tranMap map[uint32]*SimTransport
+	digest  hash.Hash64 //of every delivery, in order.
+}
+
+//SimEpoch : wall time of virtual time 0.
+var SimEpoch = time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)
+
+//NewSim : empty cluster with seed.
+func NewSim(seed int64, faults SimFaults) *Sim {
+	s := new(Sim)
+	s.Faults = faults
+	s.rng = rand.New(rand.NewSource(seed))
+	s.nodeMap = make(map[uint32]*Node)
+	s.tranMap = make(map[uint32]*SimTransport)
+	s.FaultModel = transport.NewFaultModel(s.rng)
+	s.digest = fnv.New64a()
+	if faults.PartitionEvery > 0 {
+		s.AfterFunc(faults.PartitionEvery, s.schedulePartition)
+	}
+	return s
+}
+
+//Now : Clock of the nodes of the Sim.
+func (s *Sim) Now() time.Time {
+	return SimEpoch.Add(s.now)
+}
+
+//Elapsed : virtual time since start.
+func (s *Sim) Elapsed() time.Duration {
+	return s.now
+}
+
+//AfterFunc : schedule f at d from now.
+func (s *Sim) AfterFunc(d time.Duration, f func()) Timer {
+	s.seq++
+	e := &simEvent{at: s.now + d, seq: s.seq, fn: f}
+	heap.Push(&s.events, e)
+	return e
+}
+
+//AddNode : node with cfg on this Sim, its timers on the virtual clock
+//and its jitter drawn from the Sim seed. Not started.
+func (s *Sim) AddNode(cfg *config.ClusterConfig) *Node {
+	n := NewNodeConfig(cfg, s.NewTransport(cfg.NodeID))
+	n.clock = s
+	n.rng = rand.New(rand.NewSource(s.rng.Int63()))
+	s.nodeMap[cfg.NodeID] = n
+	return n
+}
+
+//Node : node added with ID id, nil if none.
+func (s *Sim) Node(id uint32) *Node {
+	return s.nodeMap[id]
+}
+
+//NewTransport : transport of node id on this Sim.
+func (s *Sim) NewTransport(id uint32) *SimTransport {
+	t := new(SimTransport)
+	t.id = id
+	t.sim = s
+	return t
+}
+
+//Heal : remove partitions, stop scheduled ones.
+func (s *Sim) Heal() {
+	s.FaultModel.Heal()
+	s.Faults.PartitionEvery = 0
+}
+
+//Pending : num of events scheduled, stopped timers included.
+func (s *Sim) Pending() int {
+	return len(s.events)
+}
+
+//Step : run the next event, false if there is none.
+func (s *Sim) Step() bool {
+	e := s.next()
+	if e == nil {
+		return false
+	}
+	heap.Pop(&s.events)
+	e.done = true
+	s.now = e.at
+	s.Stats.Events++
+	e.fn()
+	return true
+}
+
+//RunFor : run events due in d from now, returns num of events run.
+func (s *Sim) RunFor(d time.Duration) int {
+	end := s.now + d
+	var n int
+	for e := s.next(); e != nil && e.at <= end; e = s.next() {
+		s.Step()
+		n++
+	}
+	if s.now < end {
+		s.now = end
+	}
+	return n
+}
+
+//next : earliest event, stopped timers dropped; nil if none.
+func (s *Sim) next() *simEvent {
+	for len(s.events) > 0 && s.events[0].done {
+		heap.Pop(&s.events)
+	}
+	if len(s.events) == 0 {
+		return nil
+	}
+	return s.events[0]
+}
+
+//Digest : hash of every delivery so far: time, src, dst and data.
+func (s *Sim) Digest() uint64 {
+	return s.digest.Sum64()
+}
+
+//send : schedule delivery of a copy of data, unless lost.
+func (s *Sim) send(src, dst uint32, data []byte) {
+	if s.Tap != nil {
+		s.Tap(s.now, src, dst, data)
+	}
+	n := s.Copies(s.Faults.Drop, s.Faults.Dup)
+	switch n {
+	case 0:
+		s.Stats.Dropped++
+	case 2:
+		s.Stats.Duplicated++
+	}
+	for ; n > 0; n-- {
+		dat := append([]byte(nil), data...) //owned by the receiver
+		s.AfterFunc(s.latency(), func() { s.deliver(src, dst, dat) })
+	}
+}
+
+//deliver : hand msg to its destination, if started and not cut off.
+func (s *Sim) deliver(src, dst uint32, data []byte) {
+	if s.Cut(src, dst) {
+		s.Stats.Cut++
+		return
+	}
+	t := s.tranMap[dst]
+	if t == nil || t.OnRecv == nil {
+		return
+	}
+	var rec [16]byte
+	binary.LittleEndian.PutUint64(rec[0:], uint64(s.now))
+	binary.LittleEndian.PutUint32(rec[8:], src)
+	binary.LittleEndian.PutUint32(rec[12:], dst)
+	s.digest.Write(rec[:])
+	s.digest.Write(data)
+	s.Stats.Delivered++
+	t.OnRecv(src, data)
+}
+
+func (s *Sim) latency() time.Duration {
+	d := s.Faults.MinLatency
+	if s.Faults.MaxLatency > d {
+		d += time.Duration(s.rng.Int63n(int64(s.Faults.MaxLatency - d + 1)))
+	}
+	return d
+}
+
+//schedulePartition : random split of started nodes, or heal.
+func (s *Sim) schedulePartition() {
+	if s.Faults.PartitionEvery <= 0 { //healed for good
+		return
+	}
+	defer s.AfterFunc(s.Faults.PartitionEvery, s.schedulePartition)
+	ids := make([]uint32, 0, len(s.tranMap))
+	for id := range s.tranMap {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	s.Split(ids, s.Faults.Partition)
+}
+
+// SimTransport : ITransport of one node on a Sim.
+type SimTransport struct {
+	id     uint32
+	sim    *Sim
+	OnRecv transport.OnRecvCallback
+}
+
+//SetOnRecv : register callback, before Start.
+func (t *SimTransport) SetOnRecv(cb transport.OnRecvCallback) {
+	t.OnRecv = cb
+}
+
+//MaxMsgSize : unlimited.
+func (t *SimTransport) MaxMsgSize() int {
+	return 0
+}
+
+//InlineEvents : nodes of a Sim run in its goroutine.
+func (t *SimTransport) InlineEvents() {}
+
+//Start : msgs to this node get delivered from now on.
+func (t *SimTransport) Start() error {
+	t.sim.tranMap[t.id] = t
+	return nil
+}
+
+//Stop : msgs to this node are lost from now on.
+func (t *SimTransport) Stop() error {
+	if t.sim.tranMap[t.id] == t {
+		delete(t.sim.tranMap, t.id)
+	}
+	return nil
+}
+
+//SendTo : like UDP it succeeds whether the remote node is up or not.
+func (t *SimTransport) SendTo(to uint32, data []byte) (int, error) {
+	t.sim.send(t.id, to, data)
+	return len(data), nil
+}
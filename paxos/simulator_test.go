@@ -0,0 +1,236 @@
+package paxos
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/wilem/simple-paxos/config"
+	"github.com/wilem/simple-paxos/wire"
+)
+
+var simSeed = flag.Int64("simseed", 0, "replay one Sim history with this seed, logs on")
+
+//simFaults : network of the randomized histories.
+var simFaults = SimFaults{
+	MinLatency: time.Millisecond, MaxLatency: 20 * time.Millisecond,
+	Drop: 0.05, Dup: 0.05,
+	PartitionEvery: 300 * time.Millisecond, Partition: 0.3,
+}
+
+//newSimCluster : servers 1..nsrv, all proposer, acceptor and learner,
+//plus client node 9, started on s.
+func newSimCluster(s *Sim, nsrv uint32) {
+	for _, id := range append(seqIDs(nsrv), 9) {
+		s.AddNode(simConfig(id, nsrv)).Start()
+	}
+}
+
+//simConfig : config of node id in a cluster of servers 1..nsrv.
+func simConfig(id, nsrv uint32) *config.ClusterConfig {
+	cfg := config.NewClusterConfig(id)
+	for _, sid := range seqIDs(nsrv) {
+		cfg.ServerList = append(cfg.ServerList, sid)
+		cfg.ProposerList = append(cfg.ProposerList, sid)
+		cfg.AcceptorList = append(cfg.AcceptorList, sid)
+		cfg.LearnerList = append(cfg.LearnerList, sid)
+	}
+	return cfg
+}
+
+//simHistory : client 9 sends values to random proposers of a 5-node
+//cluster on a faulty network, which heals after a while. Fails if a
+//safety invariant breaks, or a value is stuck after healing.
+func simHistory(seed int64) (*Sim, error) {
+	s := NewSim(seed, simFaults)
+	newSimCluster(s, 5)
+	chk := NewSafetyChecker(simConfig(1, 5))
+	chk.Watch(s)
+	n9 := s.Node(9)
+	for seq := uint32(1); seq <= 8; seq++ {
+		val := wire.NewValue([]byte{byte(seed), byte(seq)})
+		bs, _ := wire.NewPxsMsgRequest(seq, val).Encode()
+		n9.SendTo(1+uint32(s.rng.Intn(3)), bs)
+		s.RunFor(time.Duration(s.rng.Intn(100)) * time.Millisecond)
+	}
+	s.RunFor(2 * time.Second)
+	s.Heal()
+	s.RunFor(20 * time.Second)
+
+	if err := chk.Err(); err != nil {
+		return s, err
+	}
+	for id := uint32(1); id <= 5; id++ {
+		p := s.Node(id).proposer
+		if len(p.pendingList) != 0 {
+			return s, fmt.Errorf("node %d: %d values stuck after healing", id, len(p.pendingList))
+		}
+	}
+	return s, nil
+}
+
+//TestSim : many randomized histories; replay a failure with
+//go test -run TestSim -simseed N
+func TestSim(t *testing.T) {
+	if *simSeed != 0 {
+		s, err := simHistory(*simSeed)
+		if err != nil {
+			t.Fatalf("seed %d: %s\n", *simSeed, err)
+		}
+		log.Printf("seed %d: %+v, digest:%x\n", *simSeed, s.Stats, s.Digest())
+		return
+	}
+	nseed := int64(2000)
+	if testing.Short() {
+		nseed = 200
+	}
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+	for seed := int64(1); seed <= nseed; seed++ {
+		if _, err := simHistory(seed); err != nil {
+			t.Fatalf("seed %d: %s; replay: go test -run TestSim -simseed %d\n", seed, err, seed)
+		}
+	}
+}
+
+//simReconfigHistory : client 9 puts and changes the acceptors and
+//learners of servers 1..5, 1..3 in all roles at first, on a faulty
+//network which heals after a while. Fails if a safety invariant breaks,
+//quorums as the member ops chosen make them, or a call is stuck after
+//healing. Returns the member ops which succeeded.
+func simReconfigHistory(seed int64) (int, error) {
+	s := NewSim(seed, simFaults)
+	cfgOf := func(id uint32) *config.ClusterConfig {
+		cfg := simConfig(id, 3)
+		cfg.ServerList = seqIDs(5)
+		cfg.Alpha = 3
+		return cfg
+	}
+	for _, id := range append(seqIDs(5), 9) {
+		s.AddNode(cfgOf(id)).Start()
+	}
+	chk := NewSafetyChecker(cfgOf(1))
+	chk.Watch(s)
+	n9 := s.Node(9)
+	const ncall = 12
+	var ndone, nchanged int
+	var err error
+	var next func()
+	next = func() {
+		if ndone == ncall || err != nil {
+			return
+		}
+		done := func(ret int, res string) {
+			if ndone++; ret != int(PxsStatusOK) && ret != int(PxsStatusMemberOpRejected) {
+				err = fmt.Errorf("call %d: ret %d %s", ndone, ret, res)
+			}
+			s.AfterFunc(time.Duration(s.rng.Intn(100))*time.Millisecond, next)
+		}
+		if s.rng.Intn(2) == 0 {
+			err = n9.Do(&KVOp{Op: KVOpPut, Key: "k", Val: fmt.Sprint(ndone)}, done)
+			return
+		}
+		op := &MemberOp{Op: MemberOpAdd, Role: RoleAcceptor, ID: 1 + uint32(s.rng.Intn(5))}
+		if s.rng.Intn(2) == 0 {
+			op.Op = MemberOpRemove
+		}
+		if s.rng.Intn(3) == 0 {
+			op.Role = RoleLearner
+		}
+		err = n9.Reconfigure(op, func(ret int, res string) {
+			if ret == int(PxsStatusOK) {
+				nchanged++
+			}
+			done(ret, res)
+		})
+	}
+	s.AfterFunc(0, next)
+	s.RunFor(3 * time.Second)
+	s.Heal()
+	s.RunFor(30 * time.Second)
+
+	if err == nil {
+		err = chk.Err()
+	}
+	if err == nil && ndone != ncall {
+		err = fmt.Errorf("%d of %d calls done after healing", ndone, ncall)
+	}
+	return nchanged, err
+}
+
+//TestSimReconfigure : many randomized histories with member changes;
+//replay a failure with go test -run TestSimReconfigure -simseed N
+func TestSimReconfigure(t *testing.T) {
+	if *simSeed != 0 {
+		n, err := simReconfigHistory(*simSeed)
+		if err != nil {
+			t.Fatalf("seed %d: %s\n", *simSeed, err)
+		}
+		log.Printf("seed %d: %d member changes\n", *simSeed, n)
+		return
+	}
+	nseed := int64(500)
+	if testing.Short() {
+		nseed = 50
+	}
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+	var nchanged int
+	for seed := int64(1); seed <= nseed; seed++ {
+		n, err := simReconfigHistory(seed)
+		if err != nil {
+			t.Fatalf("seed %d: %s; replay: go test -run TestSimReconfigure -simseed %d\n", seed, err, seed)
+		}
+		nchanged += n
+	}
+	if nchanged == 0 {
+		t.Error("no member changed")
+	}
+}
+
+func TestSimReplay(t *testing.T) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+	s1, _ := simHistory(7)
+	s2, _ := simHistory(7)
+	if s1.Digest() != s2.Digest() || s1.Stats != s2.Stats || s1.Elapsed() != s2.Elapsed() {
+		t.Errorf("same seed, different runs: %+v %x, %+v %x\n", s1.Stats, s1.Digest(), s2.Stats, s2.Digest())
+	}
+	s3, _ := simHistory(8)
+	if s1.Digest() == s3.Digest() {
+		t.Error("different seeds, same run")
+	}
+	var st SimStats
+	for seed := int64(1); seed <= 20; seed++ {
+		s, _ := simHistory(seed)
+		st.Dropped += s.Stats.Dropped
+		st.Duplicated += s.Stats.Duplicated
+		st.Cut += s.Stats.Cut
+	}
+	if st.Dropped == 0 || st.Duplicated == 0 || st.Cut == 0 {
+		t.Errorf("some fault never injected: %+v\n", st)
+	}
+}
+
+func TestSimTimer(t *testing.T) {
+	s := NewSim(1, SimFaults{})
+	var got []int
+	s.AfterFunc(30*time.Millisecond, func() { got = append(got, 3) })
+	s.AfterFunc(10*time.Millisecond, func() { got = append(got, 1) })
+	tm := s.AfterFunc(20*time.Millisecond, func() { got = append(got, 2) })
+	s.AfterFunc(10*time.Millisecond, func() { got = append(got, 11) })
+	if !tm.Stop() || tm.Stop() {
+		t.Error("Stop of pending timer")
+	}
+	if n := s.RunFor(25 * time.Millisecond); n != 2 || s.Elapsed() != 25*time.Millisecond {
+		t.Error("RunFor:", n, s.Elapsed())
+	}
+	s.RunFor(time.Second)
+	if fmt.Sprint(got) != "[1 11 3]" {
+		t.Error("timer order:", got)
+	}
+}
@@ -1,16 +0,0 @@
-package main
-
-import (
-	"fmt"
-	//"log"
-	//"net"
-)
-
-func main() {
-	fmt.Println("hello.")
-}
-
-//Add - 
-func Add(a, b int) int {
-	return a + b
-}
\ No newline at end of file
@@ -0,0 +1,282 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+//NodeState : persistent state of a node, what it must not forget
+//across a restart.
+type NodeState struct {
+	InstanceID uint32
+	ClientSeq  uint32           `json:",omitempty"` //last call of the client, a seq reused is deduped
+	Acceptor   []AcceptorRecord `json:",omitempty"`
+	Chosen     []ChosenRecord   `json:",omitempty"`
+}
+
+//AcceptorRecord : acceptor state of one instance.
+type AcceptorRecord struct {
+	IID  uint32
+	Bal  uint32 //highest ballot promised
+	VBal uint32 //ballot of Val
+	Val  []byte //nil if none accepted
+}
+
+//ChosenRecord : value learned for one instance.
+type ChosenRecord struct {
+	IID uint32
+	Val []byte
+}
+
+//Record : one change of a NodeState, appended to the log between two
+//snapshots; fields not set change nothing.
+type Record struct {
+	InstanceID uint32          `json:",omitempty"`
+	ClientSeq  uint32          `json:",omitempty"`
+	Acceptor   *AcceptorRecord `json:",omitempty"`
+	Chosen     *ChosenRecord   `json:",omitempty"`
+}
+
+//Apply : change st by rec. Counters only grow and an acceptor record
+//older than the one held is ignored, so records replayed on a snapshot
+//which has them already change nothing.
+func (st *NodeState) Apply(rec *Record) {
+	if rec.InstanceID > st.InstanceID {
+		st.InstanceID = rec.InstanceID
+	}
+	if rec.ClientSeq > st.ClientSeq {
+		st.ClientSeq = rec.ClientSeq
+	}
+	if a := rec.Acceptor; a != nil {
+		i := sort.Search(len(st.Acceptor), func(i int) bool { return st.Acceptor[i].IID >= a.IID })
+		switch {
+		case i == len(st.Acceptor) || st.Acceptor[i].IID != a.IID:
+			st.Acceptor = append(st.Acceptor, AcceptorRecord{})
+			copy(st.Acceptor[i+1:], st.Acceptor[i:])
+			st.Acceptor[i] = *a
+		case a.Bal > st.Acceptor[i].Bal || (a.Bal == st.Acceptor[i].Bal && a.VBal >= st.Acceptor[i].VBal):
+			st.Acceptor[i] = *a
+		}
+	}
+	if c := rec.Chosen; c != nil {
+		i := sort.Search(len(st.Chosen), func(i int) bool { return st.Chosen[i].IID >= c.IID })
+		if i == len(st.Chosen) || st.Chosen[i].IID != c.IID { //a chosen value never changes
+			st.Chosen = append(st.Chosen, ChosenRecord{})
+			copy(st.Chosen[i+1:], st.Chosen[i:])
+			st.Chosen[i] = *c
+		}
+	}
+}
+
+//Storage : stable storage of a node, a snapshot of its state and a log
+//of the records appended since.
+type Storage interface {
+	//Load : last snapshot with the records appended since, nil if none.
+	Load() (*NodeState, error)
+	//Save : replace the snapshot, empty the log.
+	Save(st *NodeState) error
+	//Append : log recs, durable once it returns.
+	Append(recs []Record) error
+}
+
+//MemStorage : Storage in memory, survives a restart of a node in the
+//same process only.
+type MemStorage struct {
+	mu  sync.Mutex
+	bs  []byte   //snapshot
+	log [][]byte //records
+}
+
+//NewMemStorage :
+func NewMemStorage() *MemStorage {
+	return new(MemStorage)
+}
+
+//Load : copy of the state saved.
+func (s *MemStorage) Load() (*NodeState, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.bs == nil && len(s.log) == 0 {
+		return nil, nil
+	}
+	st := new(NodeState)
+	if s.bs != nil {
+		if err := json.Unmarshal(s.bs, st); err != nil {
+			return nil, err
+		}
+	}
+	for _, bs := range s.log {
+		rec := new(Record)
+		if err := json.Unmarshal(bs, rec); err != nil {
+			return nil, err
+		}
+		st.Apply(rec)
+	}
+	return st, nil
+}
+
+//Save : keep a copy of st.
+func (s *MemStorage) Save(st *NodeState) error {
+	bs, err := json.Marshal(st)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	s.bs, s.log = bs, nil
+	s.mu.Unlock()
+	return nil
+}
+
+//Append : keep a copy of recs.
+func (s *MemStorage) Append(recs []Record) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := range recs {
+		bs, err := json.Marshal(&recs[i])
+		if err != nil {
+			return err
+		}
+		s.log = append(s.log, bs)
+	}
+	return nil
+}
+
+//FileStorage : Storage in two files, the snapshot replaced as a whole
+//on Save, and the log, one JSON record per line.
+type FileStorage struct {
+	path    string //snapshot
+	logPath string
+}
+
+//NewFileStorage : state of node id in dir.
+func NewFileStorage(dir string, id uint32) *FileStorage {
+	s := new(FileStorage)
+	s.path = filepath.Join(dir, fmt.Sprintf("node%d.state", id))
+	s.logPath = filepath.Join(dir, fmt.Sprintf("node%d.log", id))
+	return s
+}
+
+//Load : nil if no file exists yet. A record cut short by a crash
+//while it was appended was never durable, it is dropped from the log.
+func (s *FileStorage) Load() (*NodeState, error) {
+	var st *NodeState
+	bs, err := os.ReadFile(s.path)
+	switch {
+	case err == nil:
+		st = new(NodeState)
+		if err = json.Unmarshal(bs, st); err != nil {
+			return nil, err
+		}
+	case !errors.Is(err, os.ErrNotExist):
+		return nil, err
+	}
+	bs, err = os.ReadFile(s.logPath)
+	if errors.Is(err, os.ErrNotExist) {
+		return st, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	dec := json.NewDecoder(bytes.NewReader(bs))
+	var end int64 //of the last whole record
+	for {
+		rec := new(Record)
+		err = dec.Decode(rec)
+		if err == io.EOF {
+			break
+		}
+		if errors.Is(err, io.ErrUnexpectedEOF) { //torn tail
+			if err = os.Truncate(s.logPath, end); err != nil {
+				return nil, err
+			}
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if st == nil {
+			st = new(NodeState)
+		}
+		st.Apply(rec)
+		end = dec.InputOffset()
+	}
+	return st, nil
+}
+
+//Append : write recs at the end of the log and sync it.
+func (s *FileStorage) Append(recs []Record) error {
+	var bs []byte
+	for i := range recs {
+		rec, err := json.Marshal(&recs[i])
+		if err != nil {
+			return err
+		}
+		bs = append(append(bs, rec...), '\n')
+	}
+	f, err := os.OpenFile(s.logPath, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err = f.Write(bs); err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+//Save : write a temp file, sync and rename it over the old snapshot,
+//then sync the dir, so a crash leaves either state whole; the log is
+//emptied then, a crash before leaves records the snapshot has already.
+func (s *FileStorage) Save(st *NodeState) error {
+	bs, err := json.Marshal(st)
+	if err != nil {
+		return err
+	}
+	tmp := s.path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err = f.Write(bs); err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err = os.Rename(tmp, s.path); err != nil {
+		return err
+	}
+	if err = syncDir(filepath.Dir(s.path)); err != nil {
+		return err
+	}
+	if err = os.Truncate(s.logPath, 0); errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+//syncDir : make a rename in dir durable.
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	err = d.Sync()
+	if cerr := d.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
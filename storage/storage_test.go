@@ -0,0 +1,96 @@
+package storage
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestStorage(t *testing.T) {
+	dir := t.TempDir()
+	st := &NodeState{
+		InstanceID: 4,
+		Acceptor: []AcceptorRecord{
+			{IID: 1, Bal: 0x20001, VBal: 0x10001, Val: []byte("a")},
+			{IID: 2, Bal: 0x10002, VBal: 0x10002, Val: []byte{}},
+			{IID: 3, Bal: 0x10003},
+		},
+		ClientSeq: 7,
+		Chosen:    []ChosenRecord{{1, []byte("a")}, {2, []byte{}}},
+	}
+	for _, s := range []Storage{NewMemStorage(), NewFileStorage(dir, 3)} {
+		if got, err := s.Load(); got != nil || err != nil {
+			t.Errorf("%T: nothing saved yet, got,err = %+v %v\n", s, got, err)
+		}
+		if err := s.Save(&NodeState{InstanceID: 1}); err != nil {
+			t.Fatalf("%T: Save: %s\n", s, err)
+		}
+		if err := s.Save(st); err != nil {
+			t.Fatalf("%T: Save: %s\n", s, err)
+		}
+		got, err := s.Load()
+		if err != nil || !reflect.DeepEqual(got, st) {
+			t.Errorf("%T: Load: %+v %v\n", s, got, err)
+		}
+	}
+	names, _ := filepath.Glob(filepath.Join(dir, "*"))
+	if len(names) != 1 || filepath.Base(names[0]) != "node3.state" {
+		t.Error("files left:", names)
+	}
+	os.WriteFile(names[0], []byte("{"), 0600)
+	if _, err := NewFileStorage(dir, 3).Load(); err == nil {
+		t.Error("truncated state loaded")
+	}
+}
+
+//TestStorageLog : records appended are loaded on top of the snapshot
+//until the next one; a torn last record is dropped, records replayed on
+//a snapshot which has them change nothing.
+func TestStorageLog(t *testing.T) {
+	dir := t.TempDir()
+	acc := func(iid, bal, vbal uint32, val string) Record {
+		return Record{Acceptor: &AcceptorRecord{IID: iid, Bal: bal, VBal: vbal, Val: []byte(val)}}
+	}
+	recs := []Record{
+		acc(2, 0x10001, 0, ""), {ClientSeq: 3}, acc(1, 0x10001, 0x10001, "a"),
+		{InstanceID: 2, Chosen: &ChosenRecord{1, []byte("a")}}, acc(2, 0x20001, 0x20001, "b"),
+	}
+	want := &NodeState{
+		InstanceID: 2, ClientSeq: 3,
+		Acceptor: []AcceptorRecord{
+			{IID: 1, Bal: 0x10001, VBal: 0x10001, Val: []byte("a")},
+			{IID: 2, Bal: 0x20001, VBal: 0x20001, Val: []byte("b")},
+		},
+		Chosen: []ChosenRecord{{1, []byte("a")}},
+	}
+	fs := NewFileStorage(dir, 3)
+	for _, s := range []Storage{NewMemStorage(), fs} {
+		if err := s.Append(recs[:2]); err != nil {
+			t.Fatalf("%T: Append: %s\n", s, err)
+		}
+		if err := s.Append(recs[2:]); err != nil {
+			t.Fatalf("%T: Append: %s\n", s, err)
+		}
+		got, err := s.Load()
+		if err != nil || !reflect.DeepEqual(got, want) {
+			t.Errorf("%T: Load: %+v %v\n", s, got, err)
+		}
+		s.Save(got)
+		s.Append(recs[:1]) //older than the snapshot
+		if got, err := s.Load(); err != nil || !reflect.DeepEqual(got, want) {
+			t.Errorf("%T: Load after Save: %+v %v\n", s, got, err)
+		}
+	}
+	//crashed while appending: the record cut short is dropped.
+	f, _ := os.OpenFile(fs.logPath, os.O_WRONLY|os.O_APPEND, 0600)
+	f.Write([]byte(`{"ClientSeq":`))
+	f.Close()
+	if got, err := fs.Load(); err != nil || !reflect.DeepEqual(got, want) {
+		t.Errorf("torn tail: %+v %v\n", got, err)
+	}
+	fs.Append([]Record{{ClientSeq: 4}})
+	if got, err := fs.Load(); err != nil || got.ClientSeq != 4 {
+		t.Errorf("append after torn tail: %+v %v\n", got, err)
+	}
+}
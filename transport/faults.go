@@ -0,0 +1,70 @@
+package transport
+
+import (
+	"math/rand"
+)
+
+// FaultModel : msg loss, dups and partitions drawn from one PRNG; the
+// network model of a NetSim and of the Sim of package paxos, which add
+// their own notion of time.
+type FaultModel struct {
+	rng  *rand.Rand
+	side map[uint32]int //node ID -> partition side, 0 if healed.
+}
+
+//NewFaultModel : faults drawn from rng, healed.
+func NewFaultModel(rng *rand.Rand) *FaultModel {
+	f := new(FaultModel)
+	f.rng = rng
+	f.side = make(map[uint32]int)
+	return f
+}
+
+//Chance : true with rate in [0,1]; draws nothing for rate 0.
+func (f *FaultModel) Chance(rate float64) bool {
+	return rate > 0 && f.rng.Float64() < rate
+}
+
+//Copies : of a msg just sent, 0 if it is lost, 2 if duplicated.
+func (f *FaultModel) Copies(drop, dup float64) int {
+	if f.Chance(drop) {
+		return 0
+	}
+	if f.Chance(dup) {
+		return 2
+	}
+	return 1
+}
+
+//Partition : cut the network between groups of nodes; nodes in no
+//group form one more group.
+func (f *FaultModel) Partition(groups ...[]uint32) {
+	f.side = make(map[uint32]int)
+	for i, ids := range groups {
+		for _, id := range ids {
+			f.side[id] = i + 1
+		}
+	}
+}
+
+//Heal : remove partitions.
+func (f *FaultModel) Heal() {
+	f.side = make(map[uint32]int)
+}
+
+//Cut : a msg from src to dst is lost to a partition.
+func (f *FaultModel) Cut(src, dst uint32) bool {
+	return f.side[src] != f.side[dst]
+}
+
+//Split : with rate, cut nodes ids in two random sides; heal otherwise.
+//ids are shuffled.
+func (f *FaultModel) Split(ids []uint32, rate float64) {
+	if len(ids) < 2 || !f.Chance(rate) {
+		f.Heal()
+		return
+	}
+	f.rng.Shuffle(len(ids), func(i, j int) { ids[i], ids[j] = ids[j], ids[i] })
+	cut := 1 + f.rng.Intn(len(ids)-1)
+	f.Partition(ids[:cut], ids[cut:])
+}
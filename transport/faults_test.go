@@ -0,0 +1,31 @@
+package transport
+
+import (
+	"math/rand"
+	"testing"
+)
+
+func TestFaultModel(t *testing.T) {
+	f := NewFaultModel(rand.New(rand.NewSource(1)))
+	if f.Chance(0) || !f.Chance(1) || f.Copies(1, 0) != 0 || f.Copies(0, 1) != 2 || f.Copies(0, 0) != 1 {
+		t.Error("rates 0 and 1 not obeyed")
+	}
+	f.Partition([]uint32{1, 2}, []uint32{3})
+	if f.Cut(1, 2) || !f.Cut(2, 3) || !f.Cut(1, 4) || !f.Cut(3, 4) {
+		t.Error("partition:", f.side)
+	}
+	f.Split([]uint32{1, 2, 3, 4}, 1)
+	cuts := 0
+	for src := uint32(1); src <= 4; src++ {
+		if f.Cut(src, src%4+1) {
+			cuts++
+		}
+	}
+	if cuts == 0 {
+		t.Error("split cut nothing:", f.side)
+	}
+	f.Split([]uint32{1, 2, 3, 4}, 0)
+	if f.Cut(1, 3) || len(f.side) != 0 {
+		t.Error("not healed:", f.side)
+	}
+}
@@ -0,0 +1,160 @@
+package transport
+
+import (
+	"errors"
+	"sort"
+	"sync"
+)
+
+//MemFabricQueueSize : most msgs in flight on a MemFabric.
+const MemFabricQueueSize = 1024 * 16
+
+//ErrFabricFull : too many msgs in flight.
+var ErrFabricFull = errors.New("mem fabric: queue full")
+
+//memPacket : one msg in flight.
+type memPacket struct {
+	src, dst uint32
+	data     []byte
+}
+
+// MemFabric : in-process network shared by the MemTransports of many nodes.
+// Msgs wait on a channel until delivered one by one with Step, so tests
+// decide when and in which goroutine every msg is handled.
+type MemFabric struct {
+	mu      sync.Mutex
+	nodeMap map[uint32]*MemTransport //ID -> transport of a started node.
+	queue   chan memPacket
+	//MaxMsgSize of the transports, 0: unlimited.
+	MaxMsgSize int
+}
+
+//NewMemFabric -
+func NewMemFabric() *MemFabric {
+	f := new(MemFabric)
+	f.nodeMap = make(map[uint32]*MemTransport)
+	f.queue = make(chan memPacket, MemFabricQueueSize)
+	return f
+}
+
+//NewTransport : transport of node id on this fabric.
+func (f *MemFabric) NewTransport(id uint32) *MemTransport {
+	t := new(MemTransport)
+	t.id = id
+	t.fabric = f
+	return t
+}
+
+//Pending : num of msgs in flight.
+func (f *MemFabric) Pending() int {
+	return len(f.queue)
+}
+
+//Step : deliver the oldest msg in flight, false if there is none.
+//Msgs to nodes which are not started are lost.
+func (f *MemFabric) Step() bool {
+	pkt, ok := f.pop()
+	if ok {
+		f.deliver(pkt)
+	}
+	return ok
+}
+
+//pop : oldest msg in flight.
+func (f *MemFabric) pop() (memPacket, bool) {
+	select {
+	case pkt := <-f.queue:
+		return pkt, true
+	default:
+		return memPacket{}, false
+	}
+}
+
+//deliver : hand msg to its destination, if started.
+func (f *MemFabric) deliver(pkt memPacket) {
+	f.mu.Lock()
+	dst := f.nodeMap[pkt.dst]
+	f.mu.Unlock()
+	if dst != nil && dst.OnRecv != nil {
+		dst.OnRecv(pkt.src, pkt.data)
+	}
+}
+
+//nodeIDs : started nodes, sorted.
+func (f *MemFabric) nodeIDs() []uint32 {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	ids := make([]uint32, 0, len(f.nodeMap))
+	for id := range f.nodeMap {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids
+}
+
+//Drain : Step until no msg is in flight or max msgs delivered, max <= 0
+//for no limit. Returns num of msgs delivered.
+func (f *MemFabric) Drain(max int) int {
+	var n int
+	for (max <= 0 || n < max) && f.Step() {
+		n++
+	}
+	return n
+}
+
+func (f *MemFabric) send(src, dst uint32, data []byte) (int, error) {
+	pkt := memPacket{src, dst, append([]byte(nil), data...)}
+	select {
+	case f.queue <- pkt:
+		return len(data), nil
+	default:
+		return -1, ErrFabricFull
+	}
+}
+
+// MemTransport : ITransport of one node on a MemFabric.
+type MemTransport struct {
+	id     uint32
+	fabric *MemFabric
+	OnRecv OnRecvCallback
+}
+
+//SetOnRecv : register callback, before Start.
+func (t *MemTransport) SetOnRecv(cb OnRecvCallback) {
+	t.OnRecv = cb
+}
+
+//MaxMsgSize : as configured on the fabric.
+func (t *MemTransport) MaxMsgSize() int {
+	return t.fabric.MaxMsgSize
+}
+
+//InlineEvents : msgs are handled in the goroutine calling Step.
+func (t *MemTransport) InlineEvents() {}
+
+//Start : attach to fabric, msgs to this node get delivered from now on.
+func (t *MemTransport) Start() error {
+	t.fabric.mu.Lock()
+	defer t.fabric.mu.Unlock()
+	if _, ok := t.fabric.nodeMap[t.id]; ok {
+		return errors.New("mem fabric: node ID in use")
+	}
+	t.fabric.nodeMap[t.id] = t
+	return nil
+}
+
+//Stop : detach from fabric.
+func (t *MemTransport) Stop() error {
+	t.fabric.mu.Lock()
+	defer t.fabric.mu.Unlock()
+	if t.fabric.nodeMap[t.id] == t {
+		delete(t.fabric.nodeMap, t.id)
+	}
+	return nil
+}
+
+//SendTo : queue a copy of data on the fabric; like UDP it succeeds
+//whether the remote node is up or not.
+func (t *MemTransport) SendTo(to uint32, data []byte) (int, error) {
+	return t.fabric.send(t.id, to, data)
+}
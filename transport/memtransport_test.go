@@ -0,0 +1,48 @@
+package transport
+
+import (
+	"testing"
+)
+
+func TestMemTransport(t *testing.T) {
+	fabric := NewMemFabric()
+	var got []string
+	onRecv := func(id uint32, dat []byte) {
+		got = append(got, string(rune('0'+id))+":"+string(dat))
+	}
+	u1, u2 := fabric.NewTransport(1), fabric.NewTransport(2)
+	u1.SetOnRecv(onRecv)
+	u2.SetOnRecv(onRecv)
+	u1.Start()
+	u2.Start()
+	if fabric.NewTransport(1).Start() == nil {
+		t.Error("two nodes with ID 1")
+	}
+
+	dat := []byte("hello,u2")
+	u1.SendTo(2, dat)
+	dat[0] = 'X' //sender may reuse its buffer
+	u2.SendTo(1, []byte("hello,u1"))
+	u2.SendTo(3, []byte("nobody"))
+	if fabric.Pending() != 3 {
+		t.Error("pending:", fabric.Pending())
+	}
+	//nothing delivered before Step
+	if len(got) != 0 || !fabric.Step() || len(got) != 1 {
+		t.Fatal("step - got:", got)
+	}
+	if n := fabric.Drain(0); n != 2 || fabric.Step() {
+		t.Error("drain:", n)
+	}
+	if len(got) != 2 || got[0] != "1:hello,u2" || got[1] != "2:hello,u1" {
+		t.Error("recv mismatch:", got)
+	}
+
+	//stopped node gets nothing
+	u2.Stop()
+	u1.SendTo(2, []byte("lost"))
+	fabric.Drain(0)
+	if len(got) != 2 {
+		t.Error("delivered to stopped node:", got)
+	}
+}
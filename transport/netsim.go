@@ -0,0 +1,131 @@
+package transport
+
+import (
+	"math/rand"
+)
+
+// NetFaults : what a NetSim does to msgs, rates are in [0,1].
+type NetFaults struct {
+	Drop     float64 //msg is lost
+	Dup      float64 //msg is delivered twice
+	Delay    float64 //msg is held back for up to MaxDelay steps
+	MaxDelay int
+	Reorder  float64 //msg is overtaken by a later one
+	//every PartitionEvery steps the network is split in two random
+	//sides with rate Partition, healed otherwise; 0: never.
+	PartitionEvery int
+	Partition      float64
+}
+
+// NetSimStats : counters of a NetSim.
+type NetSimStats struct {
+	Delivered, Dropped, Duplicated, Delayed, Reordered, Cut int
+}
+
+//simPacket : msg held by NetSim.
+type simPacket struct {
+	pkt memPacket
+	due int //step it may be delivered at.
+}
+
+// NetSim : unreliable network on top of a MemFabric. Msgs sent on the
+// fabric are dropped, duplicated, delayed, reordered or cut by partitions
+// at random, as a FaultModel says; all choices come from one seeded PRNG,
+// so with the same seed and the same nodes a run is repeated exactly.
+type NetSim struct {
+	*FaultModel
+	fabric *MemFabric
+	faults NetFaults
+	rng    *rand.Rand
+	held   []simPacket
+	step   int
+	Stats  NetSimStats
+}
+
+//NewNetSim : fault injection on fabric with seed.
+func NewNetSim(fabric *MemFabric, seed int64, faults NetFaults) *NetSim {
+	s := new(NetSim)
+	s.fabric = fabric
+	s.faults = faults
+	s.rng = rand.New(rand.NewSource(seed))
+	s.FaultModel = NewFaultModel(s.rng)
+	return s
+}
+
+//Pending : num of msgs in flight.
+func (s *NetSim) Pending() int {
+	return len(s.held) + s.fabric.Pending()
+}
+
+//Step : let one step of time pass and deliver at most one msg,
+//false if no msg is in flight.
+func (s *NetSim) Step() bool {
+	s.step++
+	if s.faults.PartitionEvery > 0 && s.step%s.faults.PartitionEvery == 0 {
+		s.Split(s.fabric.nodeIDs(), s.faults.Partition)
+	}
+	//1. take msgs sent since last step
+	for {
+		pkt, ok := s.fabric.pop()
+		if !ok {
+			break
+		}
+		s.intake(pkt)
+	}
+	if len(s.held) == 0 {
+		return false
+	}
+	//2. pick a msg which is due, the oldest unless reordered
+	var due []int
+	for i, h := range s.held {
+		if h.due <= s.step {
+			due = append(due, i)
+		}
+	}
+	if len(due) == 0 { //all delayed
+		return true
+	}
+	k := due[0]
+	if len(due) > 1 && s.Chance(s.faults.Reorder) {
+		k = due[1+s.rng.Intn(len(due)-1)]
+		s.Stats.Reordered++
+	}
+	h := s.held[k]
+	s.held = append(s.held[:k], s.held[k+1:]...)
+	//3. deliver unless cut by partition
+	if s.Cut(h.pkt.src, h.pkt.dst) {
+		s.Stats.Cut++
+		return true
+	}
+	s.Stats.Delivered++
+	s.fabric.deliver(h.pkt)
+	return true
+}
+
+//Run : Step until no msg is in flight or max steps, returns num of steps.
+func (s *NetSim) Run(max int) int {
+	var n int
+	for n < max && s.Step() {
+		n++
+	}
+	return n
+}
+
+//intake : apply drop, dup and delay to a msg just sent.
+func (s *NetSim) intake(pkt memPacket) {
+	n := s.Copies(s.faults.Drop, s.faults.Dup)
+	switch n {
+	case 0:
+		s.Stats.Dropped++
+	case 2:
+		s.Stats.Duplicated++
+	}
+	for ; n > 0; n-- {
+		due := s.step
+		if s.faults.MaxDelay > 0 && s.Chance(s.faults.Delay) {
+			s.Stats.Delayed++
+			due += 1 + s.rng.Intn(s.faults.MaxDelay)
+		}
+		s.held = append(s.held, simPacket{pkt, due})
+	}
+}
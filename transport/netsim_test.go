@@ -0,0 +1,83 @@
+package transport
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+)
+
+//netSimTrace : run a ping-pong workload on a NetSim, return what was delivered.
+func netSimTrace(seed int64, faults NetFaults) (string, NetSimStats) {
+	fabric := NewMemFabric()
+	sim := NewNetSim(fabric, seed, faults)
+	var trace bytes.Buffer
+	var us []*MemTransport
+	for id := uint32(1); id <= 4; id++ {
+		u := fabric.NewTransport(id)
+		u.SetOnRecv(func(from uint32, dat []byte) {
+			fmt.Fprintf(&trace, "%d>%d:%s ", from, u.id, dat)
+			if len(dat) < 6 { //pong
+				u.SendTo(from, append(dat, '+'))
+			}
+		})
+		u.Start()
+		us = append(us, u)
+	}
+	for i, u := range us {
+		for _, v := range us {
+			u.SendTo(v.id, []byte{byte('a' + i)})
+		}
+	}
+	sim.Run(10000)
+	return trace.String(), sim.Stats
+}
+
+func TestNetSimDeterministic(t *testing.T) {
+	faults := NetFaults{
+		Drop: 0.1, Dup: 0.1, Delay: 0.2, MaxDelay: 5, Reorder: 0.3,
+		PartitionEvery: 7, Partition: 0.5,
+	}
+	tr1, st1 := netSimTrace(42, faults)
+	tr2, st2 := netSimTrace(42, faults)
+	if tr1 != tr2 || st1 != st2 {
+		t.Error("same seed, different runs:\n", tr1, "\n", tr2)
+	}
+	tr3, _ := netSimTrace(43, faults)
+	if tr1 == tr3 {
+		t.Error("different seeds, same run")
+	}
+	if st1.Dropped == 0 || st1.Duplicated == 0 || st1.Delayed == 0 || st1.Reordered == 0 || st1.Cut == 0 {
+		t.Errorf("some fault never injected: %+v\n", st1)
+	}
+	//no faults: every ping and pong delivered
+	_, st4 := netSimTrace(42, NetFaults{})
+	if st4.Delivered != 4*4*6 || st4.Dropped+st4.Cut+st4.Reordered != 0 {
+		t.Errorf("reliable run: %+v\n", st4)
+	}
+}
+
+func TestNetSimPartition(t *testing.T) {
+	fabric := NewMemFabric()
+	sim := NewNetSim(fabric, 1, NetFaults{})
+	got := make(map[uint32]int)
+	for id := uint32(1); id <= 3; id++ {
+		u := fabric.NewTransport(id)
+		u.SetOnRecv(func(from uint32, dat []byte) { got[from]++ })
+		u.Start()
+	}
+	sim.Partition([]uint32{1}, []uint32{2})
+	for _, src := range []uint32{1, 2, 3} {
+		fabric.NewTransport(src).SendTo(src%3+1, []byte("x"))
+	}
+	sim.Run(100)
+	//1 and 2 are cut from everyone, 3 is on its own side.
+	if len(got) != 0 || sim.Stats.Cut != 3 {
+		t.Error("partition leaked:", got, sim.Stats)
+	}
+	sim.Heal()
+	fabric.NewTransport(1).SendTo(2, []byte("x"))
+	sim.Run(100)
+	if got[1] != 1 {
+		t.Error("healed msg lost:", got)
+	}
+}
@@ -0,0 +1,386 @@
+package transport
+
+import (
+	"bufio"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/wilem/simple-paxos/wire"
+)
+
+//TCP link tuning
+const (
+	TCPDialTimeout  = time.Second
+	TCPWriteTimeout = time.Second * 2
+	TCPBackoffMin   = time.Millisecond * 50
+	TCPBackoffMax   = time.Second * 5
+)
+
+//ErrPeerBackoff : peer link is down, not redialed before backoff expires.
+var ErrPeerBackoff = errors.New("tcp: peer unreachable, in backoff")
+
+// TCPTransport : stream transport, one persistent outgoing conn per peer.
+// A conn starts with the 4 byte ID of the dialing node, then carries pxs
+// msgs back to back; the receiver frames them by header siz and hands
+// each one whole to OnRecv.
+// With TLS, conns are mutually authenticated and the sender ID is the one
+// the peer cert names, a preamble claiming another one drops the conn.
+// A node missing from the book, such as an ephemeral client, gets its
+// msgs back on the conn it dialed in on.
+type TCPTransport struct {
+	id     uint32
+	addrs  AddrBook
+	tls    *tls.Config //nil: plain TCP.
+	OnRecv OnRecvCallback
+	Listen string //host:port to listen on, "": own entry of the book; set before Start.
+
+	mu       sync.Mutex          //guards the maps, listener and stopped; never held across I/O.
+	peerMap  map[uint32]*tcpPeer //remoteID -> outgoing link.
+	inConns  map[net.Conn]bool   //accepted conns.
+	inByID   map[uint32]net.Conn //remoteID -> accepted conn, of nodes missing from the book.
+	listener net.Listener
+	stopped  bool
+	wg       sync.WaitGroup //accept and read loops.
+	recvMu   sync.Mutex     //one OnRecv at a time, like UDPTransport.
+}
+
+//tcpPeer : outgoing link to one peer.
+type tcpPeer struct {
+	mu      sync.Mutex //held across dial and write, taken before t.mu.
+	conn    net.Conn
+	backoff time.Duration //wait before next dial, 0 if link is up.
+	retryAt time.Time
+}
+
+//NewTCPTransport - on legacy localhost ports
+func NewTCPTransport(id uint32) *TCPTransport {
+	return NewTCPTransportAddrs(id, nil)
+}
+
+//NewTCPTransportAddrs - nodes reached at addrs
+func NewTCPTransportAddrs(id uint32, addrs AddrBook) *TCPTransport {
+	return NewTCPTransportTLS(id, addrs, nil)
+}
+
+//NewTCPTransportTLS - nodes reached at addrs over TLS, plain TCP if cfg is nil
+func NewTCPTransportTLS(id uint32, addrs AddrBook, cfg *tls.Config) *TCPTransport {
+	t := new(TCPTransport)
+	t.id = id
+	t.addrs = addrs
+	t.tls = cfg
+	t.peerMap = make(map[uint32]*tcpPeer)
+	t.inConns = make(map[net.Conn]bool)
+	t.inByID = make(map[uint32]net.Conn)
+	return t
+}
+
+//SetOnRecv : register callback, before Start.
+func (t *TCPTransport) SetOnRecv(cb OnRecvCallback) {
+	t.OnRecv = cb
+}
+
+//MaxMsgSize : a stream has no limit.
+func (t *TCPTransport) MaxMsgSize() int {
+	return 0
+}
+
+func (t *TCPTransport) getServerAddress(serverID uint32) string {
+	return t.addrs.Addr(serverID)
+}
+
+//Start : listen and serve incoming conns.
+func (t *TCPTransport) Start() error {
+	addr := t.Listen
+	if addr == "" {
+		addr = t.getServerAddress(t.id)
+	}
+	log.Printf("[%d]TCP server listen on: %s\n", t.id, addr)
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		log.Printf("net.Listen - err:%s, addr:%+v\n", err, addr)
+		return err
+	}
+	if t.tls != nil {
+		ln = tls.NewListener(ln, t.tls)
+	}
+	t.mu.Lock()
+	t.listener = ln
+	t.stopped = false
+	t.mu.Unlock()
+
+	t.wg.Add(1)
+	go t.acceptLoop(ln)
+	return nil
+}
+
+func (t *TCPTransport) acceptLoop(ln net.Listener) {
+	defer t.wg.Done()
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			log.Printf("[%d]TCP accept - err:%s\n", t.id, err)
+			time.Sleep(TCPBackoffMin)
+			continue
+		}
+		t.mu.Lock()
+		if t.stopped {
+			t.mu.Unlock()
+			conn.Close()
+			return
+		}
+		t.inConns[conn] = true
+		t.wg.Add(1)
+		t.mu.Unlock()
+		go t.readLoop(conn)
+	}
+}
+
+//readLoop : check the preamble of an accepted conn, then read its stream.
+func (t *TCPTransport) readLoop(conn net.Conn) {
+	defer t.wg.Done()
+	var src uint32
+	defer func() {
+		t.mu.Lock()
+		delete(t.inConns, conn)
+		if t.inByID[src] == conn {
+			delete(t.inByID, src)
+		}
+		t.mu.Unlock()
+		conn.Close()
+	}()
+	var pre [4]byte //ID of dialing node
+	conn.SetReadDeadline(time.Now().Add(TCPDialTimeout))
+	if _, err := io.ReadFull(conn, pre[:]); err != nil {
+		log.Printf("[%d]TCP preamble from %s - err:%s\n", t.id, conn.RemoteAddr(), err)
+		return
+	}
+	conn.SetReadDeadline(time.Time{})
+	src = binary.LittleEndian.Uint32(pre[:])
+	if tc, ok := conn.(*tls.Conn); ok { //handshake done by the read.
+		id, err := tlsPeerID(tc)
+		if err == nil && id != src {
+			err = fmt.Errorf("%w: claimed:%d, cert:%d", ErrTLSIdentity, src, id)
+		}
+		if err != nil {
+			log.Printf("[%d]TLS peer %s - err:%s\n", t.id, conn.RemoteAddr(), err)
+			return
+		}
+	}
+	if _, ok := t.addrs[src]; !ok { //no address to dial back.
+		t.mu.Lock()
+		t.inByID[src] = conn
+		t.mu.Unlock()
+	}
+	t.readStream(conn, src)
+}
+
+//readStream : hand every frame read from src to OnRecv, whole, until
+//the conn is closed. Frames are cut from the stream of this conn alone,
+//so other conns of src never see a part of one; a stream out of sync
+//closes the conn.
+func (t *TCPTransport) readStream(conn net.Conn, src uint32) {
+	rd := bufio.NewReaderSize(conn, RecvBufSize)
+	for {
+		frm, err := readFrame(rd)
+		if err != nil {
+			if err != io.EOF && !errors.Is(err, net.ErrClosed) {
+				log.Printf("[%d]TCP read from:%d - err:%s\n", t.id, src, err)
+			}
+			conn.Close()
+			return
+		}
+		t.recvMu.Lock()
+		if t.OnRecv != nil {
+			t.OnRecv(src, frm)
+		} else {
+			PutRecvBuf(frm)
+		}
+		t.recvMu.Unlock()
+	}
+}
+
+//readFrame : next frame of rd, in a recv buffer if it fits one.
+func readFrame(rd io.Reader) ([]byte, error) {
+	var hdr [wire.PxsMsgHeaderSize]byte
+	if _, err := io.ReadFull(rd, hdr[:]); err != nil {
+		return nil, err
+	}
+	frmLen, err := wire.PxsMsgFrameLen(hdr[:])
+	if err != nil {
+		return nil, err
+	}
+	var frm []byte
+	if frmLen <= RecvBufSize {
+		frm = GetRecvBuf()[:frmLen]
+	} else {
+		frm = make([]byte, frmLen)
+	}
+	copy(frm, hdr[:])
+	if _, err = io.ReadFull(rd, frm[len(hdr):]); err != nil {
+		PutRecvBuf(frm)
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	return frm, nil
+}
+
+//SendTo - send bytes to remote node, dial it if needed; not once stopped.
+//Sends to one peer are serialized by its own lock, a slow dial or write
+//holds up no other peer.
+func (t *TCPTransport) SendTo(to uint32, data []byte) (int, error) {
+	t.mu.Lock()
+	if t.stopped {
+		t.mu.Unlock()
+		return -1, ErrNotStarted
+	}
+	p, ok := t.peerMap[to]
+	if !ok {
+		p = new(tcpPeer)
+		t.peerMap[to] = p
+	}
+	in := t.inByID[to]
+	t.mu.Unlock()
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.conn == nil && in != nil {
+		return t.writeIn(to, in, data)
+	}
+	if p.conn == nil {
+		if time.Now().Before(p.retryAt) {
+			return -1, ErrPeerBackoff
+		}
+		conn, err := t.dial(to)
+		if err != nil {
+			p.fail()
+			return -1, err
+		}
+		t.mu.Lock()
+		if t.stopped { //stopped while dialing.
+			t.mu.Unlock()
+			conn.Close()
+			return -1, ErrNotStarted
+		}
+		t.wg.Add(1)
+		t.mu.Unlock()
+		p.conn, p.backoff = conn, 0
+		go func() { //msgs back from a peer which can't dial us.
+			defer t.wg.Done()
+			t.readStream(conn, to)
+		}()
+	}
+
+	p.conn.SetWriteDeadline(time.Now().Add(TCPWriteTimeout))
+	n, err := p.conn.Write(data)
+	if err != nil {
+		//stream may hold a partial msg now, start a new one.
+		log.Printf("[%d]TCP write to:%d - err:%s, reconnect.\n", t.id, to, err)
+		p.conn.Close()
+		p.conn = nil
+		p.fail()
+	}
+	return n, err
+}
+
+//writeIn : send on the conn node to dialed in on; a broken one is closed,
+//its read loop forgets it.
+func (t *TCPTransport) writeIn(to uint32, in net.Conn, data []byte) (int, error) {
+	in.SetWriteDeadline(time.Now().Add(TCPWriteTimeout))
+	n, err := in.Write(data)
+	if err != nil {
+		log.Printf("[%d]TCP write back to:%d - err:%s\n", t.id, to, err)
+		in.Close()
+	}
+	return n, err
+}
+
+//dial : new conn to peer, with preamble sent.
+func (t *TCPTransport) dial(to uint32) (net.Conn, error) {
+	conn, err := t.dialConn(to)
+	if err != nil {
+		return nil, err
+	}
+	var pre [4]byte
+	binary.LittleEndian.PutUint32(pre[:], t.id)
+	conn.SetWriteDeadline(time.Now().Add(TCPWriteTimeout))
+	if _, err = conn.Write(pre[:]); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+//dialConn : plain conn to peer, or TLS one to the peer its cert names.
+func (t *TCPTransport) dialConn(to uint32) (net.Conn, error) {
+	addr := t.getServerAddress(to)
+	if t.tls == nil {
+		return net.DialTimeout("tcp", addr, TCPDialTimeout)
+	}
+	dialer := &net.Dialer{Timeout: TCPDialTimeout}
+	conn, err := tls.DialWithDialer(dialer, "tcp", addr, t.tls)
+	if err != nil {
+		return nil, err
+	}
+	id, err := tlsPeerID(conn)
+	if err == nil && id != to {
+		err = fmt.Errorf("%w: dialed:%d, cert:%d", ErrTLSIdentity, to, id)
+	}
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+//fail : double the backoff of a broken link.
+func (p *tcpPeer) fail() {
+	p.backoff *= 2
+	if p.backoff < TCPBackoffMin {
+		p.backoff = TCPBackoffMin
+	}
+	if p.backoff > TCPBackoffMax {
+		p.backoff = TCPBackoffMax
+	}
+	p.retryAt = time.Now().Add(p.backoff)
+}
+
+//Stop : close listener and all conns, wait for loops to exit.
+func (t *TCPTransport) Stop() error {
+	t.mu.Lock()
+	t.stopped = true
+	var err error
+	if t.listener != nil {
+		err = t.listener.Close()
+		t.listener = nil
+	}
+	for conn := range t.inConns {
+		conn.Close()
+	}
+	peers := make([]*tcpPeer, 0, len(t.peerMap))
+	for _, p := range t.peerMap {
+		peers = append(peers, p)
+	}
+	t.mu.Unlock()
+	for _, p := range peers { //a write in progress ends by its deadline.
+		p.mu.Lock()
+		if p.conn != nil {
+			p.conn.Close()
+			p.conn = nil
+		}
+		p.mu.Unlock()
+	}
+	t.wg.Wait()
+	return err
+}
@@ -0,0 +1,268 @@
+package transport
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/wilem/simple-paxos/wire"
+)
+
+//tcpSink : collects stream bytes per sender.
+type tcpSink struct {
+	mu     sync.Mutex
+	got    map[uint32]*bytes.Buffer
+	chunks map[uint32]int //OnRecv calls per sender
+}
+
+func (s *tcpSink) OnRecv(id uint32, dat []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.got == nil {
+		s.got = make(map[uint32]*bytes.Buffer)
+	}
+	if s.got[id] == nil {
+		s.got[id] = new(bytes.Buffer)
+	}
+	if s.chunks == nil {
+		s.chunks = make(map[uint32]int)
+	}
+	s.got[id].Write(dat)
+	s.chunks[id]++
+}
+
+//wait : until n bytes from id arrived.
+func (s *tcpSink) wait(id uint32, n int) []byte {
+	for i := 0; i < 200; i++ {
+		s.mu.Lock()
+		if b := s.got[id]; b != nil && b.Len() >= n {
+			bs := append([]byte(nil), b.Bytes()...)
+			s.mu.Unlock()
+			return bs
+		}
+		s.mu.Unlock()
+		time.Sleep(time.Millisecond * 10)
+	}
+	return nil
+}
+
+func TestTCPTransport(t *testing.T) {
+	var s1, s2 tcpSink
+	u1 := NewTCPTransport(11)
+	u1.SetOnRecv(s1.OnRecv)
+	u2 := NewTCPTransport(12)
+	u2.SetOnRecv(s2.OnRecv)
+	if e := u1.Start(); e != nil {
+		t.Fatalf("Start failed:%s\n", e)
+	}
+	defer u1.Stop()
+	if e := u2.Start(); e != nil {
+		t.Fatalf("Start failed:%s\n", e)
+	}
+
+	//msgs sent back to back come out as one stream, framed by hdr.siz.
+	var sent []byte
+	for i := uint32(1); i <= 3; i++ {
+		bs, _ := wire.NewPxsMsgCommit(i, 100+i, &wire.Value{}).Encode()
+		if n, e := u1.SendTo(12, bs); n != len(bs) || e != nil {
+			t.Fatalf("SendTo failed: n:%d(%d),e:%s\n", n, len(bs), e)
+		}
+		sent = append(sent, bs...)
+	}
+	got := s2.wait(11, len(sent))
+	if !bytes.Equal(got, sent) {
+		t.Fatal("stream mismatch - got:", got)
+	}
+	if s2.chunks[11] != 3 { //one frame per OnRecv
+		t.Error("chunks:", s2.chunks)
+	}
+	var buf bytes.Buffer
+	for i := uint32(1); i <= 3; i++ {
+		msg, _, _, err := wire.DecodeOnePxsMsg(&buf, got)
+		got = nil
+		if cmt, ok := msg.(*wire.PxsMsgCommit); !ok || err != nil || cmt.Hdr.IID != i {
+			t.Error("decode", i, "- msg,err =", msg, err)
+		}
+	}
+
+	//peer goes away: send fails, then backs off instead of redialing.
+	u2.Stop()
+	var err error
+	for i := 0; i < 10 && err == nil; i++ {
+		_, err = u1.SendTo(12, sent)
+		time.Sleep(time.Millisecond * 10)
+	}
+	if err == nil {
+		t.Fatal("SendTo to stopped peer succeeded")
+	}
+	if _, err = u1.SendTo(12, sent); !errors.Is(err, ErrPeerBackoff) {
+		t.Error("expect backoff - err:", err)
+	}
+
+	//peer is back: reconnect once backoff expired.
+	s2 = tcpSink{}
+	u2 = NewTCPTransport(12)
+	u2.SetOnRecv(s2.OnRecv)
+	if e := u2.Start(); e != nil {
+		t.Fatalf("restart failed:%s\n", e)
+	}
+	defer u2.Stop()
+	for i := 0; i < 100; i++ {
+		if _, err = u1.SendTo(12, sent); err == nil {
+			break
+		}
+		time.Sleep(TCPBackoffMin)
+	}
+	if err != nil || s2.wait(11, len(sent)) == nil {
+		t.Error("no reconnect - err:", err)
+	}
+
+	//stopped: no redial.
+	u1.Stop()
+	if _, err = u1.SendTo(12, sent); !errors.Is(err, ErrNotStarted) {
+		t.Error("SendTo after Stop - err:", err)
+	}
+}
+
+//TestTCPTransportStreams : frames are cut from each conn alone; a
+//partial frame on one conn of a node garbles none of another, a stream
+//out of sync is closed.
+func TestTCPTransportStreams(t *testing.T) {
+	var s2 tcpSink
+	u2 := NewTCPTransport(12)
+	u2.SetOnRecv(s2.OnRecv)
+	if e := u2.Start(); e != nil {
+		t.Fatalf("Start failed:%s\n", e)
+	}
+	defer u2.Stop()
+	dial := func() net.Conn {
+		conn, err := net.Dial("tcp", u2.getServerAddress(12))
+		if err != nil {
+			t.Fatal("Dial:", err)
+		}
+		conn.Write([]byte{11, 0, 0, 0}) //preamble
+		return conn
+	}
+	a, _ := wire.NewPxsMsgCommit(1, 101, &wire.Value{}).Encode()
+	b, _ := wire.NewPxsMsgCommit(2, 102, &wire.Value{}).Encode()
+	c1, c2 := dial(), dial()
+	defer c1.Close()
+	defer c2.Close()
+	c1.Write(a[:wire.PxsMsgHeaderSize+2])
+	time.Sleep(time.Millisecond * 20)
+	c2.Write(b)
+	if got := s2.wait(11, len(b)); !bytes.Equal(got, b) {
+		t.Fatal("frame of 2nd conn garbled:", got)
+	}
+	c1.Write(a[wire.PxsMsgHeaderSize+2:])
+	if got := s2.wait(11, len(a)+len(b)); !bytes.Equal(got, append(b, a...)) || s2.chunks[11] != 2 {
+		t.Error("frame of 1st conn:", got, s2.chunks)
+	}
+
+	c3 := dial()
+	defer c3.Close()
+	c3.Write(make([]byte, wire.PxsMsgHeaderSize)) //no magic
+	c3.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := c3.Read(make([]byte, 1)); err != io.EOF {
+		t.Error("conn out of sync not closed:", err)
+	}
+}
+
+//TestTCPTransportSlowPeer : a peer which reads nothing holds up sends to
+//itself only.
+func TestTCPTransportSlowPeer(t *testing.T) {
+	var s2 tcpSink
+	free := freeTCPAddrs(t, 2)
+	slow, err := net.Listen("tcp", LocalIPAddr+":0")
+	if err != nil {
+		t.Fatal("Listen:", err)
+	}
+	defer slow.Close()
+	go func() { //accept, never read.
+		for {
+			conn, err := slow.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+	book := AddrBook{11: free[0], 12: free[1], 13: slow.Addr().String()}
+	u1 := NewTCPTransportAddrs(11, book)
+	u2 := NewTCPTransportAddrs(12, book)
+	u2.SetOnRecv(s2.OnRecv)
+	for _, u := range []*TCPTransport{u1, u2} {
+		if e := u.Start(); e != nil {
+			t.Fatalf("Start failed:%s\n", e)
+		}
+		defer u.Stop()
+	}
+	done := make(chan struct{})
+	go func() { //fills the socket buffers, blocks until the write deadline.
+		defer close(done)
+		u1.SendTo(13, make([]byte, 64<<20))
+	}()
+	time.Sleep(time.Millisecond * 100)
+	bs, _ := wire.NewPxsMsgCommit(1, 101, &wire.Value{}).Encode()
+	start := time.Now()
+	if _, e := u1.SendTo(12, bs); e != nil {
+		t.Fatal("SendTo:", e)
+	}
+	if d := time.Since(start); d > TCPWriteTimeout/2 {
+		t.Error("send to 12 waited for 13:", d)
+	}
+	if s2.wait(11, len(bs)) == nil {
+		t.Error("not received")
+	}
+	<-done
+}
+
+//TestTCPTransportUnknownPeer : a node missing from the book, listening
+//nowhere it could be dialed at, gets replies on the conn it dialed in on.
+func TestTCPTransportUnknownPeer(t *testing.T) {
+	var srv, cli tcpSink
+	free := freeTCPAddrs(t, 1)
+	const cid = 1<<24 + 7
+	u1 := NewTCPTransportAddrs(11, AddrBook{11: free[0]})
+	u1.SetOnRecv(srv.OnRecv)
+	if e := u1.Start(); e != nil {
+		t.Fatalf("Start failed:%s\n", e)
+	}
+	defer u1.Stop()
+	u2 := NewTCPTransportAddrs(cid, AddrBook{11: free[0], cid: LocalIPAddr + ":0"})
+	u2.SetOnRecv(cli.OnRecv)
+	if e := u2.Start(); e != nil {
+		t.Fatalf("Start failed:%s\n", e)
+	}
+	defer u2.Stop()
+
+	req, _ := wire.NewPxsMsgQuery(1, 1).Encode()
+	if _, e := u2.SendTo(11, req); e != nil {
+		t.Fatal("SendTo server:", e)
+	}
+	if srv.wait(cid, len(req)) == nil {
+		t.Fatal("request not received")
+	}
+	rsp, _ := wire.NewPxsMsgLog(1, 1, 0, 2, 11, nil).Encode()
+	if n, e := u1.SendTo(cid, rsp); n != len(rsp) || e != nil {
+		t.Fatal("SendTo client: n,e =", n, e)
+	}
+	if got := cli.wait(11, len(rsp)); !bytes.Equal(got, rsp) {
+		t.Error("reply mismatch - got:", got)
+	}
+
+	//client gone: no way back to it.
+	u2.Stop()
+	var err error
+	for i := 0; i < 100 && err == nil; i++ {
+		time.Sleep(time.Millisecond * 10)
+		_, err = u1.SendTo(cid, rsp)
+	}
+	if err == nil {
+		t.Error("SendTo to gone client succeeded")
+	}
+}
@@ -0,0 +1,181 @@
+package transport
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/wilem/simple-paxos/wire"
+)
+
+//testCA : throwaway CA issuing node certs.
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pool *x509.CertPool
+	der  []byte
+}
+
+func newTestCA(t *testing.T) *testCA {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal("GenerateKey:", err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "paxos test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal("CreateCertificate:", err)
+	}
+	ca := &testCA{key: key, der: der, pool: x509.NewCertPool()}
+	ca.cert, _ = x509.ParseCertificate(der)
+	ca.pool.AddCert(ca.cert)
+	return ca
+}
+
+//issue : cert with common name cn, and its key.
+func (ca *testCA) issue(t *testing.T, cn string) (certDER []byte, key *ecdsa.PrivateKey) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal("GenerateKey:", err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	certDER, err = x509.CreateCertificate(rand.Reader, tmpl, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal("CreateCertificate:", err)
+	}
+	return certDER, key
+}
+
+//config : TLS config of a node presenting a cert with common name cn.
+func (ca *testCA) config(t *testing.T, cn string) *tls.Config {
+	der, key := ca.issue(t, cn)
+	return NewTLSConfig(ca.pool, tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key})
+}
+
+//freeTCPAddrs : n distinct local addresses free for now.
+func freeTCPAddrs(t *testing.T, n int) []string {
+	var addrs []string
+	for i := 0; i < n; i++ {
+		ln, err := net.Listen("tcp", LocalIPAddr+":0")
+		if err != nil {
+			t.Fatal("Listen:", err)
+		}
+		defer ln.Close()
+		addrs = append(addrs, ln.Addr().String())
+	}
+	return addrs
+}
+
+func TestTLSNodeID(t *testing.T) {
+	cases := map[string]bool{"node7": true, "node4294967295": true,
+		"node": false, "7": false, "node-7": false, "nodex": false, "node4294967296": false}
+	for cn, ok := range cases {
+		id, err := TLSNodeID(&x509.Certificate{Subject: pkix.Name{CommonName: cn}})
+		if (err == nil) != ok || (!ok && !errors.Is(err, ErrTLSIdentity)) {
+			t.Error(cn, "- id,err =", id, err)
+		}
+	}
+}
+
+func TestLoadTLSConfig(t *testing.T) {
+	if cfg, err := LoadTLSConfig("", "", ""); cfg != nil || err != nil {
+		t.Error("TLS off: cfg,err =", cfg, err)
+	}
+	ca := newTestCA(t)
+	der, key := ca.issue(t, "node1")
+	keyDER, _ := x509.MarshalECPrivateKey(key)
+	dir := t.TempDir()
+	files := map[string]*pem.Block{
+		"ca.pem":   {Type: "CERTIFICATE", Bytes: ca.der},
+		"node.pem": {Type: "CERTIFICATE", Bytes: der},
+		"node.key": {Type: "EC PRIVATE KEY", Bytes: keyDER},
+	}
+	for name, blk := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), pem.EncodeToMemory(blk), 0600); err != nil {
+			t.Fatal("WriteFile:", err)
+		}
+	}
+	ca1, crt1, key1 := filepath.Join(dir, "ca.pem"), filepath.Join(dir, "node.pem"), filepath.Join(dir, "node.key")
+	cfg, err := LoadTLSConfig(ca1, crt1, key1)
+	if err != nil || len(cfg.Certificates) != 1 || cfg.ClientAuth != tls.RequireAndVerifyClientCert {
+		t.Fatal("LoadTLSConfig: cfg,err =", cfg, err)
+	}
+	if _, err = LoadTLSConfig(ca1, crt1, ""); err == nil {
+		t.Error("key missing, no error")
+	}
+	if _, err = LoadTLSConfig(crt1+"x", crt1, key1); err == nil {
+		t.Error("CA file missing, no error")
+	}
+}
+
+//TestTCPTransportTLS : sender ID comes from the peer cert; impostors,
+//wrong peers and certs of other CAs get no link.
+func TestTCPTransportTLS(t *testing.T) {
+	ca := newTestCA(t)
+	free := freeTCPAddrs(t, 2)
+	addrs := AddrBook{21: free[0], 22: free[1]}
+	addrs[24] = addrs[22] //node 22 answers for 24
+	var s2 tcpSink
+	u1 := NewTCPTransportTLS(21, addrs, ca.config(t, "node21"))
+	u2 := NewTCPTransportTLS(22, addrs, ca.config(t, "node22"))
+	u2.SetOnRecv(s2.OnRecv)
+	for _, u := range []*TCPTransport{u1, u2} {
+		if err := u.Start(); err != nil {
+			t.Fatal("Start:", err)
+		}
+		defer u.Stop()
+	}
+	//1. authenticated link
+	bs, _ := wire.NewPxsMsgCommit(1, 101, &wire.Value{}).Encode()
+	if n, err := u1.SendTo(22, bs); n != len(bs) || err != nil {
+		t.Fatal("SendTo: n,err =", n, err)
+	}
+	if got := s2.wait(21, len(bs)); got == nil {
+		t.Fatal("nothing received from 21")
+	}
+	//2. peer cert names another node
+	if _, err := u1.SendTo(24, bs); !errors.Is(err, ErrTLSIdentity) {
+		t.Error("dial 24, got 22: err =", err)
+	}
+	//3. node 23 claiming to be 21, node 21 with a cert of another CA
+	imp := NewTCPTransportTLS(21, addrs, ca.config(t, "node23"))
+	imp.SendTo(22, bs)
+	defer imp.Stop()
+	rogue := NewTCPTransportTLS(21, addrs, newTestCA(t).config(t, "node21"))
+	if _, err := rogue.SendTo(22, bs); err == nil {
+		t.Error("cert of another CA accepted")
+	}
+	defer rogue.Stop()
+	time.Sleep(100 * time.Millisecond)
+	s2.mu.Lock()
+	defer s2.mu.Unlock()
+	if len(s2.got) != 1 || s2.got[21].Len() != len(bs) {
+		t.Errorf("unauthenticated bytes received: %v\n", s2.got)
+	}
+}
@@ -0,0 +1,274 @@
+package transport
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/wilem/simple-paxos/wire"
+)
+
+//ITransport - interface of tranport class
+type ITransport interface {
+	Start() error
+	Stop() error
+	SendTo(uint32, []byte) (int, error)
+	//SetOnRecv : register callback, before Start.
+	SetOnRecv(OnRecvCallback)
+	//MaxMsgSize : largest data delivered in one piece, 0 if unlimited.
+	MaxMsgSize() int
+}
+
+// OnRecvCallback is a callback type for user to register with.
+// The callee owns data: the transport never touches it again, the
+// callee may keep it and, once done, give it back with PutRecvBuf.
+type OnRecvCallback func(uint32, []byte)
+
+//AddrBook : node ID -> host:port; a node not listed is on the legacy
+//localhost port, see ids2addr.
+type AddrBook map[uint32]string
+
+//Addr : host:port of node id.
+func (b AddrBook) Addr(id uint32) string {
+	if addr, ok := b[id]; ok {
+		return addr
+	}
+	return ids2addr(0, id)
+}
+
+//LegacyAddrBook : nodes ids on their legacy localhost ports.
+func LegacyAddrBook(ids []uint32) AddrBook {
+	b := make(AddrBook)
+	for _, id := range ids {
+		b[id] = ids2addr(0, id)
+	}
+	return b
+}
+
+//UDPEnvelopeSize : sender node ID in front of every datagram.
+const UDPEnvelopeSize = 4
+
+//ErrNotStarted : transport is not started.
+var ErrNotStarted = errors.New("transport not started")
+
+// UDPTransport encapsulate UDP transport. One socket sends and receives;
+// every datagram starts with the ID of its sender, so nodes can be
+// anywhere. Nodes missing from the address book are answered at the
+// address they were last heard from, with a Secret the address of a
+// datagram sealed by them only.
+type UDPTransport struct {
+	id       uint32
+	addrs    AddrBook
+	conn     *net.UDPConn
+	mu       sync.Mutex
+	routeMap map[uint32]*net.UDPAddr //remoteID -> address, resolved or learned.
+	wg       sync.WaitGroup          //recv loop.
+	OnRecv   OnRecvCallback
+	Listen   string //host:port to listen on, "": own entry of the book; set before Start.
+	Secret   []byte //key frames are sealed with, nil: none; set before Start.
+}
+
+//NewUDPTransport - on legacy localhost ports
+func NewUDPTransport(id uint32) *UDPTransport {
+	return NewUDPTransportAddrs(id, nil)
+}
+
+//NewUDPTransportAddrs - nodes reached at addrs
+func NewUDPTransportAddrs(id uint32, addrs AddrBook) *UDPTransport {
+	u := new(UDPTransport)
+	u.id = id
+	u.addrs = addrs
+	u.routeMap = make(map[uint32]*net.UDPAddr)
+	return u
+}
+
+//SetOnRecv : register callback, before Start.
+func (t *UDPTransport) SetOnRecv(cb OnRecvCallback) {
+	t.OnRecv = cb
+}
+
+//MaxMsgSize : one datagram, less the envelope.
+func (t *UDPTransport) MaxMsgSize() int {
+	return wire.MaxDatagramSize - UDPEnvelopeSize
+}
+
+//RecvBufSize : recv buffer size used in server recv loop,
+//large enough for any UDP datagram, so none is truncated.
+const RecvBufSize int = 1024 * 64
+
+//recvBufPool : buffers of RecvBufSize, each handed to OnRecv with the
+//data read into it and given back by the callee.
+var recvBufPool = sync.Pool{New: func() interface{} {
+	bs := make([]byte, RecvBufSize)
+	return &bs
+}}
+
+//GetRecvBuf : buffer from the pool, room for any datagram.
+func GetRecvBuf() []byte {
+	return *recvBufPool.Get().(*[]byte)
+}
+
+//PutRecvBuf : give back data received, or any part of it, for reuse;
+//it must not be used anymore. Buffers not from GetRecvBuf are left to
+//the GC.
+func PutRecvBuf(data []byte) {
+	if cap(data) != RecvBufSize {
+		return
+	}
+	bs := data[:cap(data)]
+	recvBufPool.Put(&bs)
+}
+
+//Start : start a UDP server loop
+func (t *UDPTransport) Start() error {
+	t.mu.Lock()
+	started := t.conn != nil
+	t.mu.Unlock()
+	if started {
+		return errors.New("udp: already started")
+	}
+	addr := t.Listen
+	if addr == "" {
+		addr = t.addrs.Addr(t.id)
+	}
+	log.Printf("[%d]UDP server listen on: %s\n", t.id, addr)
+	laddr, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		log.Printf("net.ResolveUDPAddr - err:%s, addr:%+v\n", err, addr)
+		return err
+	}
+	conn, err := net.ListenUDP("udp", laddr)
+	if err != nil {
+		log.Printf("net.ListenUDP - err:%s, laddr:%+v\n", err, laddr)
+		return err
+	}
+	//closed by Stop, which ends the loop.
+	t.mu.Lock()
+	t.conn = conn
+	t.mu.Unlock()
+
+	t.wg.Add(1)
+	go func() {
+		defer t.wg.Done()
+		//every datagram in a buffer of its own, handed over to OnRecv.
+		buffer := GetRecvBuf()
+		defer func() { PutRecvBuf(buffer) }()
+		for {
+			n, raddr, err := conn.ReadFromUDP(buffer)
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			if err != nil {
+				fmt.Println("UDP server read:", buffer[:n],
+					"from ", addr, "err:", err)
+				time.Sleep(time.Second)
+				continue
+			}
+			if n < UDPEnvelopeSize {
+				log.Printf("[%d]Wrong package received - from:%s, len:%d\n", t.id, raddr, n)
+				continue
+			}
+			src := binary.LittleEndian.Uint32(buffer)
+			if t.learnable(src, buffer[UDPEnvelopeSize:n]) {
+				t.learn(src, raddr)
+			}
+
+			if t.OnRecv != nil {
+				t.OnRecv(src, buffer[UDPEnvelopeSize:n])
+				buffer = GetRecvBuf()
+			} else {
+				log.Printf("[%d]t.OnRecv: - buffer:%d\n", t.id, buffer[:n])
+			}
+		}
+	}()
+
+	return nil
+}
+
+//SendTo - send bytes to remote node.
+func (t *UDPTransport) SendTo(to uint32, data []byte) (int, error) {
+	t.mu.Lock()
+	conn := t.conn
+	t.mu.Unlock()
+	if conn == nil {
+		return -1, ErrNotStarted
+	}
+	raddr, err := t.route(to)
+	if err != nil {
+		return -1, err
+	}
+	env := make([]byte, UDPEnvelopeSize+len(data))
+	binary.LittleEndian.PutUint32(env, t.id)
+	copy(env[UDPEnvelopeSize:], data)
+	n, err := conn.WriteToUDP(env, raddr)
+	if n < UDPEnvelopeSize {
+		return -1, err
+	}
+	return n - UDPEnvelopeSize, err
+}
+
+//route : address of node to.
+func (t *UDPTransport) route(to uint32) (*net.UDPAddr, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if raddr, ok := t.routeMap[to]; ok {
+		return raddr, nil
+	}
+	raddr, err := net.ResolveUDPAddr("udp", t.addrs.Addr(to))
+	if err != nil {
+		return nil, err
+	}
+	t.routeMap[to] = raddr
+	return raddr, nil
+}
+
+//learnable : datagram frm tells where node src is: src is missing from
+//the book and, with a Secret, frm is sealed by src.
+func (t *UDPTransport) learnable(src uint32, frm []byte) bool {
+	if _, ok := t.addrs[src]; ok {
+		return false
+	}
+	if t.Secret == nil {
+		return true
+	}
+	id, err := wire.VerifyPxsMsg(frm, t.Secret)
+	return err == nil && id == src
+}
+
+//learn : node src is at raddr.
+func (t *UDPTransport) learn(src uint32, raddr *net.UDPAddr) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if old, ok := t.routeMap[src]; ok && old.String() == raddr.String() {
+		return
+	}
+	t.routeMap[src] = raddr
+}
+
+//Stop : close the socket and wait for the recv loop to exit; no OnRecv
+//is called once it returns. May be started again.
+func (t *UDPTransport) Stop() error {
+	t.mu.Lock()
+	conn := t.conn
+	t.conn = nil
+	t.mu.Unlock()
+	if conn == nil {
+		return nil
+	}
+	err := conn.Close()
+	t.wg.Wait()
+	return err
+}
+
+// utilities
+func ids2addr(src, dst uint32) string {
+	str := fmt.Sprintf("127.0.0.1:5%02d%02d", src, dst)
+	return str
+}
+
+//LocalIPAddr : local IP address
+const LocalIPAddr = "127.0.0.1"
@@ -0,0 +1,281 @@
+package transport
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/wilem/simple-paxos/wire"
+)
+
+func TestUDPTranport(t *testing.T) {
+	var u1, u2 *UDPTransport
+	u1 = NewUDPTransport(1)
+	u1.OnRecv = OnRecv
+	//XXX set cb before Start server loop
+	e := u1.Start()
+	if e != nil {
+		t.Errorf("Start failed:%s\n", e)
+	}
+
+	u2 = NewUDPTransport(2)
+	u2.OnRecv = OnRecv
+	e = u2.Start()
+	if e != nil {
+		t.Errorf("Start failed:%s\n", e)
+	}
+
+	var n int
+	str1 := "hello,u2!000"
+	dat1 := []byte(str1)
+	n, e = u1.SendTo(2, dat1)
+	if n != len(dat1) || e != nil {
+		t.Errorf("SendTo failed: n:%d(%d),e:%s\n", n, len(dat1), e)
+	}
+
+	str2 := "hello,u1!000"
+	dat2 := []byte(str2)
+	n, e = u2.SendTo(1, dat2)
+	if n != len(dat2) || e != nil {
+		t.Errorf("SendTo failed: n:%d(%d),e:%s\n", n, len(dat2), e)
+	}
+
+	//wait for server to exit.
+	time.Sleep(time.Second * 1)
+
+	recvMu.Lock()
+	ss1, ok1 := recvStrs[1]
+	ss2, ok2 := recvStrs[2]
+	recvMu.Unlock()
+	if ok1 && ok2 && ss1 == str1 && ss2 == str2 {
+		fmt.Println("recv matched.")
+	} else {
+		t.Errorf("msg mismatch - recv'ed ss1:%s, ss2:%s\n", ss1, ss2)
+	}
+
+	//clean up
+	for _, u := range []*UDPTransport{u1, u2} {
+		if err := u.Stop(); err != nil {
+			t.Error("Stop:", err)
+		}
+	}
+}
+
+var recvStrs map[uint32]string
+var recvMu sync.Mutex //OnRecv runs in the recv loops.
+
+func OnRecv(id uint32, dat []byte) {
+	fmt.Printf("[%d]OnRecv - from:%d, data:%+v\n", 0, id, dat)
+	recvMu.Lock()
+	defer recvMu.Unlock()
+	if recvStrs == nil {
+		recvStrs = make(map[uint32]string)
+	}
+	recvStrs[id] = string(dat)
+
+}
+
+//freeUDPAddrs : n distinct local addresses nobody listens on, for now.
+func freeUDPAddrs(t *testing.T, n int) []string {
+	var addrs []string
+	for i := 0; i < n; i++ {
+		conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.ParseIP(LocalIPAddr)})
+		if err != nil {
+			t.Fatal("ListenUDP:", err)
+		}
+		defer conn.Close()
+		addrs = append(addrs, conn.LocalAddr().String())
+	}
+	return addrs
+}
+
+//TestUDPTransportAddrs : nodes at any address, sender ID in the datagram,
+//replies to a node missing from the book go where it was heard from.
+func TestUDPTransportAddrs(t *testing.T) {
+	type recv struct {
+		from uint32
+		dat  string
+	}
+	free := freeUDPAddrs(t, 2)
+	book := AddrBook{121: free[0], 122: free[1]}
+	got := make(chan recv, 4)
+	u1 := NewUDPTransportAddrs(121, book)
+	u2 := NewUDPTransportAddrs(122, book)
+	u1.SetOnRecv(func(from uint32, dat []byte) {
+		got <- recv{from, string(dat)}
+		u1.SendTo(from, append([]byte("re:"), dat...))
+	})
+	u2.SetOnRecv(func(from uint32, dat []byte) { got <- recv{from, string(dat)} })
+	//client with an ephemeral port, only it knows where it is.
+	cli := NewUDPTransportAddrs(1000, AddrBook{1000: LocalIPAddr + ":0", 121: book[121]})
+	cli.SetOnRecv(func(from uint32, dat []byte) { got <- recv{from, string(dat)} })
+	if _, err := cli.SendTo(121, []byte("x")); !errors.Is(err, ErrNotStarted) {
+		t.Error("send before Start:", err)
+	}
+	for _, u := range []*UDPTransport{u1, u2, cli} {
+		if err := u.Start(); err != nil {
+			t.Fatal("Start:", err)
+		}
+		defer u.Stop()
+	}
+	if n, err := u2.SendTo(121, []byte("hi")); n != 2 || err != nil {
+		t.Error("SendTo:", n, err)
+	}
+	cli.SendTo(121, []byte("ping"))
+	want := map[recv]bool{
+		{122, "hi"}: true, {121, "re:hi"}: true, {1000, "ping"}: true, {121, "re:ping"}: true,
+	}
+	for len(want) > 0 {
+		select {
+		case r := <-got:
+			if !want[r] {
+				t.Error("unexpected:", r)
+			}
+			delete(want, r)
+		case <-time.After(time.Second * 2):
+			t.Fatal("not received:", want)
+		}
+	}
+}
+
+//TestUDPTransportLearn : a datagram moves the route of a node missing
+//from the book only, and with a Secret only if the node sealed it.
+func TestUDPTransportLearn(t *testing.T) {
+	key := []byte("secret")
+	free := freeUDPAddrs(t, 3)
+	book := AddrBook{141: free[0], 142: free[1]}
+	got := make(chan uint32, 4)
+	u1 := NewUDPTransportAddrs(141, book)
+	u1.Secret = key
+	u1.SetOnRecv(func(from uint32, dat []byte) { got <- from })
+	if err := u1.Start(); err != nil {
+		t.Fatal("Start:", err)
+	}
+	defer u1.Stop()
+	evil, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.ParseIP(LocalIPAddr)})
+	if err != nil {
+		t.Fatal("ListenUDP:", err)
+	}
+	defer evil.Close()
+	frm, _ := wire.NewPxsMsgHello(0).Encode()
+	send := func(src uint32, bs []byte) {
+		t.Helper()
+		env := binary.LittleEndian.AppendUint32(nil, src)
+		evil.WriteToUDP(append(env, bs...), u1.conn.LocalAddr().(*net.UDPAddr))
+		select {
+		case <-got:
+		case <-time.After(time.Second * 2):
+			t.Fatal("not received from", src)
+		}
+	}
+	forged, _ := wire.SealPxsMsg(frm, 142, []byte("guess"))
+	sealed, _ := wire.SealPxsMsg(frm, 142, key)
+	send(142, forged)
+	send(142, sealed) //listed: never learned
+	send(1000, frm)
+	send(1000, forged)
+	if r, _ := u1.route(142); r.String() != free[1] {
+		t.Error("route of listed node moved:", r)
+	}
+	u1.mu.Lock()
+	_, ok := u1.routeMap[1000]
+	u1.mu.Unlock()
+	if ok {
+		t.Error("route learned from unsealed or forged frames")
+	}
+	sealed, _ = wire.SealPxsMsg(frm, 1000, key)
+	send(1000, sealed)
+	if r, _ := u1.route(1000); r.String() != evil.LocalAddr().String() {
+		t.Error("route of sealed sender not learned:", r)
+	}
+}
+
+//TestUDPTransportStop : a transport stopped frees its address, calls
+//OnRecv no more and can be started again.
+func TestUDPTransportStop(t *testing.T) {
+	free := freeUDPAddrs(t, 2)
+	book := AddrBook{131: free[0], 132: free[1]}
+	got := make(chan string, 4)
+	u1 := NewUDPTransportAddrs(131, book)
+	u2 := NewUDPTransportAddrs(132, book)
+	u2.SetOnRecv(func(from uint32, dat []byte) { got <- string(dat) })
+	if err := u1.Start(); err != nil {
+		t.Fatal("Start:", err)
+	}
+	defer u1.Stop()
+	for i := 0; i < 3; i++ {
+		if err := u2.Start(); err != nil {
+			t.Fatal("Start", i, "- err:", err)
+		}
+		if err := u2.Start(); err == nil {
+			t.Error("started twice")
+		}
+		u1.SendTo(132, []byte{byte('0' + i)})
+		select {
+		case s := <-got:
+			if s != string(rune('0'+i)) {
+				t.Error("recv:", s)
+			}
+		case <-time.After(time.Second * 2):
+			t.Fatal("not received, round", i)
+		}
+		if err := u2.Stop(); err != nil {
+			t.Fatal("Stop:", err)
+		}
+		if err := u2.Stop(); err != nil {
+			t.Error("Stop twice:", err)
+		}
+		if _, err := u2.SendTo(131, []byte("x")); !errors.Is(err, ErrNotStarted) {
+			t.Error("send after Stop:", err)
+		}
+		u1.SendTo(132, []byte("lost"))
+	}
+	select {
+	case s := <-got:
+		t.Error("received while stopped:", s)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+//TestUDPTransportOwnedBuffers : OnRecv may keep what it gets, later
+//datagrams land in other buffers.
+func TestUDPTransportOwnedBuffers(t *testing.T) {
+	free := freeUDPAddrs(t, 2)
+	book := AddrBook{151: free[0], 152: free[1]}
+	const num = 64
+	got := make(chan []byte, num)
+	u1 := NewUDPTransportAddrs(151, book)
+	u2 := NewUDPTransportAddrs(152, book)
+	u2.SetOnRecv(func(from uint32, dat []byte) { got <- dat })
+	for _, u := range []*UDPTransport{u1, u2} {
+		if err := u.Start(); err != nil {
+			t.Fatal("Start:", err)
+		}
+		defer u.Stop()
+	}
+	for i := 0; i < num; i++ {
+		u1.SendTo(152, []byte(fmt.Sprintf("msg-%02d", i)))
+		time.Sleep(time.Millisecond) //no loss on loopback
+	}
+	seen := make(map[string]bool)
+	var kept [][]byte
+	for len(kept) < num {
+		select {
+		case dat := <-got:
+			kept = append(kept, dat)
+		case <-time.After(2 * time.Second):
+			t.Fatal("received:", len(kept), "of", num)
+		}
+	}
+	for _, dat := range kept {
+		seen[string(dat)] = true
+		PutRecvBuf(dat)
+	}
+	if len(seen) != num {
+		t.Error("datagrams overwritten, distinct:", len(seen))
+	}
+}
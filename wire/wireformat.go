@@ -0,0 +1,906 @@
+package wire
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"log"
+)
+
+//IPxsMsg : generic pxs msg type
+type IPxsMsg interface {
+	Encode() ([]byte, error)
+	//Decode([]byte) error
+}
+
+//PxsMsgType : type field
+type PxsMsgType uint32
+
+//all pxs msg type
+const (
+	PxsMsgTypeHello    PxsMsgType = 0x00 //00 msg: node <-> node, version and config check
+	PxsMsgTypeRequest  PxsMsgType = 0x0a //0a msg: pro -> cli
+	PxsMsgTypePrepare  PxsMsgType = 0x1a //1a msg: pro -> acc
+	PxsMsgTypePromise  PxsMsgType = 0x1b //1b msg: acc -> pro
+	PxsMsgTypeAccept   PxsMsgType = 0x2a //2a msg: pro -> acc
+	PxsMsgTypeAccepted PxsMsgType = 0x2b //2b msg: acc -> pro
+	PxsMsgTypeCommit   PxsMsgType = 0x3a //3a msg: pro -> acc
+	PxsMsgTypeResponse PxsMsgType = 0x0b //0b msg: pro -> cli
+	PxsMsgTypeQuery    PxsMsgType = 0x0c //0c msg: cli -> lrn, read the log
+	PxsMsgTypeLog      PxsMsgType = 0x0d //0d msg: lrn -> cli, entry of the log
+	PxsMsgTypeFragment PxsMsgType = 0xf0 //f0 msg: node -> node, piece of a large msg
+)
+
+//PxsMsgMagic : first 2 bytes of every pxs msg, "PX" on the wire.
+const PxsMsgMagic uint16 = 0x5850
+
+//protocol versions understood by this build.
+const (
+	PxsProtoVersionMin uint8 = 1 //oldest version we can decode
+	PxsProtoVersion    uint8 = 1 //current version, used when encoding
+)
+
+//PxsMsgHeaderSize : encoded size of PxsMsgHeader
+const PxsMsgHeaderSize = 16
+
+//PxsMsgCRCSize : CRC32C trailer over header and payload of every msg.
+const PxsMsgCRCSize = 4
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+//PxsMsgFlagAuth : header flag of a sealed frame, see SealPxsMsg.
+const PxsMsgFlagAuth uint8 = 0x01
+
+//PxsMsgAuthSize : sender ID and HMAC-SHA256 of a sealed frame, before its CRC.
+const PxsMsgAuthSize = 4 + sha256.Size
+
+//decode errors
+var (
+	//nothing consumed, feed more bytes:
+	ErrPxsMsgIncomplete = errors.New("pxs msg: incomplete frame")
+	//stream out of sync, reset the buffer:
+	ErrPxsMsgMagic    = errors.New("pxs msg: bad magic")
+	ErrPxsMsgTooLarge = errors.New("pxs msg: frame too large")
+	//frame consumed and dropped:
+	ErrPxsMsgVersion     = errors.New("pxs msg: unsupported protocol version")
+	ErrPxsMsgChecksum    = errors.New("pxs msg: checksum mismatch")
+	ErrPxsMsgUnknownType = errors.New("pxs msg: unknown type")
+	ErrPxsMsgMalformed   = errors.New("pxs msg: malformed payload")
+	ErrValueTooLarge     = errors.New("pxs msg: value too large")
+	ErrPxsMsgAuth        = errors.New("pxs msg: authentication failed")
+)
+
+//PxsMsgHeader of all pxs msg
+type PxsMsgHeader struct {
+	Mgc uint16     //PxsMsgMagic
+	Ver uint8      //protocol version of the msg
+	Flg uint8      //PxsMsgFlagAuth, or 0
+	Siz uint32     //msg length
+	Typ PxsMsgType //msg type ID: 00,0a,1a,1b,2a,2b,3a,0b,0c,0d
+	IID uint32     //instance ID or Sequence num of request.
+}
+
+//newPxsMsgHeader : header of current protocol version.
+func newPxsMsgHeader(typ PxsMsgType, iid, siz uint32) PxsMsgHeader {
+	return PxsMsgHeader{
+		Mgc: PxsMsgMagic, Ver: PxsProtoVersion,
+		Siz: siz, Typ: typ, IID: iid,
+	}
+}
+
+//DefaultMaxValueSize : largest client Value by default, fits in one UDP datagram.
+const DefaultMaxValueSize uint32 = 63 * 1024
+
+//MaxValueSize : largest Value accepted by Encode and DecodeOnePxsMsg;
+//msgs larger than MaxDatagramSize travel as PxsMsgFragment.
+const MaxValueSize uint32 = 4 * 1024 * 1024
+
+//MaxDatagramSize : largest UDP payload.
+const MaxDatagramSize = 65507
+
+//pxsMsgMaxFixedSize : largest fixed part of a msg payload, Log's.
+const pxsMsgMaxFixedSize = 5 * 4
+
+//Value : Client Value, size <= MaxValueSize
+type Value struct {
+	Siz uint32 //0: Value is none.
+	Oct []byte //if oct == nil, then val == num;
+}
+
+//NewValue : Value of bytes oct.
+func NewValue(oct []byte) *Value {
+	return &Value{Siz: uint32(len(oct)), Oct: oct}
+}
+
+//Size : len of bytes
+func (v Value) Size() uint32 {
+	return uint32(len(v.Oct))
+}
+
+//check : v is consistent and not too large to be sent.
+func (v Value) check() error {
+	if v.Siz != v.Size() {
+		return fmt.Errorf("%w: value siz:%d, len(oct):%d", ErrPxsMsgMalformed, v.Siz, len(v.Oct))
+	}
+	if v.Siz > MaxValueSize {
+		return fmt.Errorf("%w: %d > %d", ErrValueTooLarge, v.Siz, MaxValueSize)
+	}
+	return nil
+}
+
+//IsNone : v is a None Value.
+func (v Value) IsNone() bool {
+	if v.Siz == 0 || len(v.Oct) == 0 {
+		return true
+	}
+	return false
+}
+
+//////////////////////////////////////////////////////////////////////////////////
+
+//PxsMsgHello : announce supported protocol versions to a peer.
+//Its layout is frozen, fields are only appended, so it is decoded
+//whatever version it carries.
+type PxsMsgHello struct {
+	Hdr     PxsMsgHeader // hdr.type = PxsMsgTypeHello
+	MinVer  uint32       //oldest version the sender can decode
+	MaxVer  uint32       //newest version the sender can decode
+	CfgHash uint64       //hash of the cluster config of the sender, 0: not sent
+}
+
+//NewPxsMsgHello : hello with local version range and cluster config hash.
+func NewPxsMsgHello(cfgHash uint64) *PxsMsgHello {
+	m := new(PxsMsgHello)
+	m.Hdr = newPxsMsgHeader(PxsMsgTypeHello, 0, 4*2+8) //minVer,maxVer,cfgHash
+	m.MinVer = uint32(PxsProtoVersionMin)
+	m.MaxVer = uint32(PxsProtoVersion)
+	m.CfgHash = cfgHash
+	return m
+}
+
+//Encode : struct to bytes
+func (m PxsMsgHello) Encode() ([]byte, error) {
+	var data = []interface{}{
+		m.Hdr, //header
+		m.MinVer, m.MaxVer, m.CfgHash,
+	}
+	return encodeFrame(data)
+}
+
+//CheckVersion : error unless the sender speaks our PxsProtoVersion and
+//sent its hello in a version we can decode.
+func (m PxsMsgHello) CheckVersion() error {
+	if m.MinVer > uint32(PxsProtoVersion) || m.MaxVer < uint32(PxsProtoVersion) ||
+		m.Hdr.Ver < PxsProtoVersionMin || m.Hdr.Ver > PxsProtoVersion {
+		return fmt.Errorf("%w: ours %d, peer %d..%d",
+			ErrPxsMsgVersion, PxsProtoVersion, m.MinVer, m.MaxVer)
+	}
+	return nil
+}
+
+//PxsMsgRequest :
+type PxsMsgRequest struct {
+	Hdr PxsMsgHeader // hdr.type = PxsMsgTypeRequest, hdr.iid as seq num of Value;
+	Val Value
+}
+
+//NewPxsMsgRequest :  new msg
+func NewPxsMsgRequest(seq uint32, val *Value) *PxsMsgRequest {
+	m := new(PxsMsgRequest)
+	m.Hdr = newPxsMsgHeader(PxsMsgTypeRequest, seq,
+		4+val.Size()) //val.siz + val.oct
+	m.Val = *val
+	return m
+}
+
+//serialize :
+func serialize(data []interface{}) ([]byte, error) {
+	buf := new(bytes.Buffer)
+	for _, v := range data {
+		err := binary.Write(buf, binary.LittleEndian, v)
+		//TODO assert
+		if err != nil {
+			log.Println("binary.Wrile failed:", err)
+			return nil, err
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+//encodeFrame : serialize msg fields and append CRC32C of them.
+func encodeFrame(data []interface{}) ([]byte, error) {
+	bs, err := serialize(data)
+	if err != nil {
+		return nil, err
+	}
+	sum := crc32.Checksum(bs, crcTable)
+	return binary.LittleEndian.AppendUint32(bs, sum), nil
+}
+
+//deserialize : element of flds should be pointer to filed of struct.
+func deserialize(flds []interface{}, r io.Reader) error {
+	for _, v := range flds {
+		if err := binary.Read(r, binary.LittleEndian, v); err != nil {
+			log.Println("deserialize failed, err:", err)
+			return err
+		}
+	}
+	return nil
+}
+
+//Encode : struct to bytes
+func (m PxsMsgRequest) Encode() ([]byte, error) {
+	if err := m.Val.check(); err != nil {
+		return nil, err
+	}
+	var data = []interface{}{
+		m.Hdr, m.Val.Siz, m.Val.Oct,
+	}
+	return encodeFrame(data)
+}
+
+//PxsMsgResponse : P0b msg
+type PxsMsgResponse struct {
+	Hdr PxsMsgHeader // hdr.type = PxsMsgResponse, hdr.iid as seq num of Value;
+	Ret uint32       // return code; OK or TIMEOUT;
+	Val Value        // result of the Value applied; none if ret != OK;
+}
+
+//NewPxsMsgResponse : val may be nil for none.
+func NewPxsMsgResponse(iid, ret uint32, val *Value) *PxsMsgResponse {
+	m := new(PxsMsgResponse)
+	m.Ret = ret
+	if val != nil {
+		m.Val = *val
+	}
+	m.Hdr = newPxsMsgHeader(PxsMsgTypeResponse, iid,
+		4+4+m.Val.Siz) //ret,val.siz,val.oct
+	return m
+}
+
+//Encode : struct to bytes
+func (m PxsMsgResponse) Encode() ([]byte, error) {
+	if err := m.Val.check(); err != nil {
+		return nil, err
+	}
+	var data = []interface{}{
+		m.Hdr, //header
+		m.Ret, m.Val.Siz, m.Val.Oct,
+	}
+	return encodeFrame(data)
+}
+
+//PxsMsgQuery : ask a learner for the entry of the log at hdr.iid;
+//iid 0 asks for the learner state only.
+type PxsMsgQuery struct {
+	Hdr PxsMsgHeader // hdr.type = PxsMsgTypeQuery, hdr.iid as instance asked;
+	Seq uint32       // query num of the client, echoed in the reply;
+}
+
+//NewPxsMsgQuery :
+func NewPxsMsgQuery(iid, seq uint32) *PxsMsgQuery {
+	m := new(PxsMsgQuery)
+	m.Hdr = newPxsMsgHeader(PxsMsgTypeQuery, iid, 4) //seq
+	m.Seq = seq
+	return m
+}
+
+//Encode : struct to bytes
+func (m PxsMsgQuery) Encode() ([]byte, error) {
+	var data = []interface{}{
+		m.Hdr, //header
+		m.Seq,
+	}
+	return encodeFrame(data)
+}
+
+//PxsMsgLog : reply to PxsMsgQuery.
+type PxsMsgLog struct {
+	Hdr    PxsMsgHeader // hdr.type = PxsMsgTypeLog, hdr.iid as instance asked;
+	Seq    uint32       // seq of the query;
+	Ret    uint32       // return code; OK if val is chosen for iid;
+	Next   uint32       // 1st instance the learner has not applied;
+	Leader uint32       // leader proposer the learner knows of, 0: none;
+	Val    Value        // value chosen for iid; none if ret != OK;
+}
+
+//NewPxsMsgLog : val may be nil for none.
+func NewPxsMsgLog(iid, seq, ret, next, leader uint32, val *Value) *PxsMsgLog {
+	m := new(PxsMsgLog)
+	m.Seq, m.Ret, m.Next, m.Leader = seq, ret, next, leader
+	if val != nil {
+		m.Val = *val
+	}
+	m.Hdr = newPxsMsgHeader(PxsMsgTypeLog, iid,
+		4*5+m.Val.Siz) //seq,ret,next,leader,val.siz,val.oct
+	return m
+}
+
+//Encode : struct to bytes
+func (m PxsMsgLog) Encode() ([]byte, error) {
+	if err := m.Val.check(); err != nil {
+		return nil, err
+	}
+	var data = []interface{}{
+		m.Hdr, //header
+		m.Seq, m.Ret, m.Next, m.Leader,
+		m.Val.Siz, m.Val.Oct,
+	}
+	return encodeFrame(data)
+}
+
+//PxsMsgPrepare :
+type PxsMsgPrepare struct {
+	Hdr PxsMsgHeader
+	Bal uint32
+}
+
+//Invalidballot : None Value;
+const Invalidballot = uint32(0xFFFFFFFF)
+
+//NewPxsMsgPrepare :
+func NewPxsMsgPrepare(iid, bal uint32) *PxsMsgPrepare {
+	m := new(PxsMsgPrepare)
+	m.Hdr = newPxsMsgHeader(PxsMsgTypePrepare, iid, 4)
+	m.Bal = bal
+	return m
+}
+
+//Encode : struct to bytes
+func (m PxsMsgPrepare) Encode() ([]byte, error) {
+	var data = []interface{}{
+		m.Hdr, //header
+		m.Bal,
+	}
+	return encodeFrame(data)
+}
+
+//PxsMsgPromise :
+type PxsMsgPromise struct {
+	Hdr   PxsMsgHeader
+	Acc   uint32 //acceptor ID;
+	Bal   uint32 //bal > mbal;
+	MVBal uint32 //voted ballot: if mbal != Invalidballot then mval is an old Value;
+	MVal  Value  //voted Value: old Value replied;
+}
+
+//NewPxsMsgPromise :
+func NewPxsMsgPromise(iid, acc, bal, mVbal uint32, val *Value) *PxsMsgPromise {
+	m := new(PxsMsgPromise)
+	m.Hdr = newPxsMsgHeader(PxsMsgTypePromise, iid,
+		4*4+val.Siz) //acc,bal,mvbal, siz, oct
+	m.Acc = acc
+	m.Bal = bal
+	m.MVBal = mVbal
+	m.MVal = *val
+	return m
+}
+
+//Encode :
+func (m PxsMsgPromise) Encode() ([]byte, error) {
+	if err := m.MVal.check(); err != nil {
+		return nil, err
+	}
+	var data = []interface{}{
+		m.Hdr, //header
+		m.Acc, m.Bal, m.MVBal,
+		m.MVal.Siz, m.MVal.Oct,
+	}
+	return encodeFrame(data)
+}
+
+//acceptors state:
+//maxbal[a]  : promised ballot
+//maxVbal[a] : accepted ballot
+//maxval[a]  : accepted Value
+
+//PxsMsgAccept :
+type PxsMsgAccept struct {
+	Hdr PxsMsgHeader
+	Bal uint32
+	Val Value
+}
+
+//NewPxsMsgAccept :
+func NewPxsMsgAccept(iid, bal uint32, val *Value) *PxsMsgAccept {
+	m := new(PxsMsgAccept)
+	m.Bal = bal
+	m.Val = *val
+	m.Hdr = newPxsMsgHeader(PxsMsgTypeAccept, iid,
+		4+4+m.Val.Siz) //bal,val.siz,val.oct
+	return m
+}
+
+//Encode :
+func (m PxsMsgAccept) Encode() (bs []byte, err error) {
+	if err = m.Val.check(); err != nil {
+		return nil, err
+	}
+	var data = []interface{}{
+		m.Hdr, //header
+		m.Bal, m.Val.Siz, m.Val.Oct,
+	}
+	return encodeFrame(data)
+}
+
+//PxsMsgAccepted :
+type PxsMsgAccepted struct {
+	Hdr PxsMsgHeader
+	Acc uint32
+	Bal uint32
+	Val Value
+}
+
+//NewPxsMsgAccepted :
+func NewPxsMsgAccepted(iid, acc, bal uint32, val *Value) *PxsMsgAccepted {
+	m := new(PxsMsgAccepted)
+	m.Acc, m.Bal, m.Val = acc, bal, *val
+	m.Hdr = newPxsMsgHeader(PxsMsgTypeAccepted, iid,
+		4*3+m.Val.Siz) //acc,bal,val.siz, val.oct
+	return m
+}
+
+//Encode :
+func (m PxsMsgAccepted) Encode() ([]byte, error) {
+	if err := m.Val.check(); err != nil {
+		return nil, err
+	}
+	var data = []interface{}{
+		m.Hdr, //header
+		m.Acc, m.Bal, m.Val.Siz,
+		m.Val.Oct,
+	}
+	return encodeFrame(data)
+}
+
+//PxsMsgFragment : piece of an encoded msg too large for one datagram.
+//The receiver decodes the msg only once all pieces are present,
+//its own checksum guards the reassembled bytes.
+type PxsMsgFragment struct {
+	Hdr PxsMsgHeader // hdr.iid = iid of the fragmented msg
+	FID uint32       //fragmented msg ID, unique per sender
+	Off uint32       //offset of oct in the fragmented msg
+	Tot uint32       //total size of the fragmented msg
+	Oct Value        //bytes [off, off+oct.siz) of the fragmented msg
+}
+
+//fragment frame size on top of the bytes it carries.
+const PxsFragmentOverhead = PxsMsgHeaderSize + 4*4 + PxsMsgCRCSize
+
+//pxsFragmentChunk : most bytes of a msg carried by one fragment.
+const pxsFragmentChunk = MaxDatagramSize - PxsFragmentOverhead
+
+//NewPxsMsgFragment :
+func NewPxsMsgFragment(iid, fid, off, tot uint32, oct []byte) *PxsMsgFragment {
+	m := new(PxsMsgFragment)
+	m.FID, m.Off, m.Tot = fid, off, tot
+	m.Oct = Value{uint32(len(oct)), oct}
+	m.Hdr = newPxsMsgHeader(PxsMsgTypeFragment, iid,
+		4*4+m.Oct.Siz) //fid,off,tot,oct.siz,oct.oct
+	return m
+}
+
+//Encode :
+func (m PxsMsgFragment) Encode() ([]byte, error) {
+	var data = []interface{}{
+		m.Hdr, //header
+		m.FID, m.Off, m.Tot,
+		m.Oct.Siz, m.Oct.Oct,
+	}
+	return encodeFrame(data)
+}
+
+//FragmentPxsMsg : split an encoded msg into fragments of at most chunk bytes.
+func FragmentPxsMsg(fid uint32, frm []byte, chunk int) ([][]byte, error) {
+	if len(frm) < PxsMsgHeaderSize || len(frm) > maxPxsMsgFrameSize() {
+		return nil, fmt.Errorf("%w: can not fragment %d bytes", ErrPxsMsgTooLarge, len(frm))
+	}
+	if chunk <= 0 || chunk > pxsFragmentChunk {
+		chunk = pxsFragmentChunk
+	}
+	iid := binary.LittleEndian.Uint32(frm[12:]) //hdr.iid
+	var frgs [][]byte
+	for off := 0; off < len(frm); off += chunk {
+		end := off + chunk
+		if end > len(frm) {
+			end = len(frm)
+		}
+		bs, err := NewPxsMsgFragment(iid, fid, uint32(off), uint32(len(frm)), frm[off:end]).Encode()
+		if err != nil {
+			return nil, err
+		}
+		frgs = append(frgs, bs)
+	}
+	return frgs, nil
+}
+
+//PxsMsgAssembly : reassembly of one fragmented msg.
+type PxsMsgAssembly struct {
+	fid uint32
+	frm []byte            //fragmented msg, tot bytes
+	got map[uint32]uint32 //offset -> end of the bytes received
+	nrd uint32            //num of bytes received
+}
+
+//Add : store frg, returns the whole msg once all its bytes are present.
+//A fragment of another msg restarts the reassembly; one overlapping the
+//bytes received, but for a duplicate, is an error and dropped.
+func (a *PxsMsgAssembly) Add(frg *PxsMsgFragment) ([]byte, error) {
+	if frg.Tot < PxsMsgHeaderSize || int64(frg.Tot) > int64(maxPxsMsgFrameSize()) ||
+		uint64(frg.Off)+uint64(frg.Oct.Siz) > uint64(frg.Tot) || frg.Oct.Siz == 0 {
+		return nil, fmt.Errorf("%w: fragment off:%d siz:%d tot:%d",
+			ErrPxsMsgMalformed, frg.Off, frg.Oct.Siz, frg.Tot)
+	}
+	if a.frm == nil || a.fid != frg.FID || uint32(len(a.frm)) != frg.Tot {
+		a.fid = frg.FID //drop pieces of an older msg
+		a.frm = make([]byte, frg.Tot)
+		a.got = make(map[uint32]uint32)
+		a.nrd = 0
+	}
+	end := frg.Off + frg.Oct.Siz
+	if a.got[frg.Off] == end { //duplicates are ignored
+		return nil, nil
+	}
+	for off, e := range a.got {
+		if frg.Off < e && off < end {
+			return nil, fmt.Errorf("%w: fragment off:%d siz:%d overlaps off:%d siz:%d",
+				ErrPxsMsgMalformed, frg.Off, frg.Oct.Siz, off, e-off)
+		}
+	}
+	a.got[frg.Off] = end
+	a.nrd += uint32(copy(a.frm[frg.Off:], frg.Oct.Oct))
+	if a.nrd < frg.Tot {
+		return nil, nil
+	}
+	frm := a.frm
+	a.frm, a.got = nil, nil
+	return frm, nil
+}
+
+//PxsMsgCommit : value val of ballot bal is chosen.
+type PxsMsgCommit struct {
+	Hdr PxsMsgHeader
+	Bal uint32
+	Val Value
+}
+
+//NewPxsMsgCommit :
+func NewPxsMsgCommit(iid, bal uint32, val *Value) *PxsMsgCommit {
+	m := new(PxsMsgCommit)
+	m.Bal = bal
+	m.Val = *val
+	m.Hdr = newPxsMsgHeader(PxsMsgTypeCommit, iid,
+		4+4+m.Val.Siz) //bal,val.siz,val.oct
+	return m
+}
+
+//Encode :
+func (m PxsMsgCommit) Encode() (bs []byte, err error) {
+	if err = m.Val.check(); err != nil {
+		return nil, err
+	}
+	var data = []interface{}{
+		m.Hdr, //header
+		m.Bal, m.Val.Siz, m.Val.Oct,
+	}
+	return encodeFrame(data)
+}
+
+//DecodeOnePxsMsg : decode one msg framed by hdr.siz, returns num of unread bytes.
+//bs is appended to buf first; pass nil to decode the next buffered msg.
+//A partial frame is left in buf with ErrPxsMsgIncomplete, a frame of
+//unknown type is consumed with ErrPxsMsgUnknownType; on ErrPxsMsgMagic
+//or ErrPxsMsgTooLarge nothing is consumed, the stream is out of sync and
+//buf should be reset.
+func DecodeOnePxsMsg(buf *bytes.Buffer, bs []byte) (msg interface{}, hdr *PxsMsgHeader, rem int, err error) {
+	msg, hdr, _, rem, err = decodeOnePxsMsg(buf, bs, nil)
+	return
+}
+
+//DecodeOnePxsMsgAuth : DecodeOnePxsMsg in a cluster with secret key; a
+//frame not sealed with key fails with ErrPxsMsgAuth, src is the sender
+//a sealed frame names.
+func DecodeOnePxsMsgAuth(buf *bytes.Buffer, bs []byte, key []byte) (msg interface{}, hdr *PxsMsgHeader, src uint32, rem int, err error) {
+	return decodeOnePxsMsg(buf, bs, key)
+}
+
+//decodeOnePxsMsg : sealed frames are checked with key, unless nil.
+func decodeOnePxsMsg(buf *bytes.Buffer, bs []byte, key []byte) (msg interface{}, hdr *PxsMsgHeader, src uint32, rem int, err error) {
+	//0. feed buffer
+	buf.Write(bs) //feed
+	raw := buf.Bytes()
+	if len(raw) >= 2 && binary.LittleEndian.Uint16(raw) != PxsMsgMagic {
+		//not a pxs msg at all, tell it without waiting for a full header.
+		return nil, nil, 0, len(raw), fmt.Errorf("%w: 0x%04x", ErrPxsMsgMagic,
+			binary.LittleEndian.Uint16(raw))
+	}
+	if len(raw) < PxsMsgHeaderSize {
+		return nil, nil, 0, len(raw), ErrPxsMsgIncomplete
+	}
+	//1. header
+	hdr = new(PxsMsgHeader)
+	flds := []interface{}{
+		&hdr.Mgc, &hdr.Ver, &hdr.Flg, &hdr.Siz, &hdr.Typ, &hdr.IID,
+	}
+	deserialize(flds, bytes.NewReader(raw[:PxsMsgHeaderSize]))
+	bodyEnd := PxsMsgHeaderSize + int(hdr.Siz)
+	frmLen, err := PxsMsgFrameLen(raw)
+	if err != nil { //don't wait for it
+		return nil, nil, 0, len(raw), err
+	}
+	if len(raw) < frmLen { //wait for the rest of the frame
+		return nil, nil, 0, len(raw), ErrPxsMsgIncomplete
+	}
+	frm := buf.Next(frmLen) //consumed whatever it holds.
+	rem = buf.Len()
+	if hdr.Typ != PxsMsgTypeHello &&
+		(hdr.Ver < PxsProtoVersionMin || hdr.Ver > PxsProtoVersion) {
+		return nil, hdr, src, rem, fmt.Errorf("%w: %d, supported %d..%d", ErrPxsMsgVersion,
+			hdr.Ver, PxsProtoVersionMin, PxsProtoVersion)
+	}
+	sumAt := frmLen - PxsMsgCRCSize
+	if crc32.Checksum(frm[:sumAt], crcTable) != binary.LittleEndian.Uint32(frm[sumAt:]) {
+		return nil, hdr, src, rem, ErrPxsMsgChecksum
+	}
+	if hdr.Flg&PxsMsgFlagAuth != 0 {
+		src = binary.LittleEndian.Uint32(frm[bodyEnd:])
+		if key != nil && !hmac.Equal(frm[bodyEnd+4:sumAt], pxsMsgMAC(frm[:bodyEnd+4], key)) {
+			return nil, hdr, src, rem, fmt.Errorf("%w: bad mac, src:%d", ErrPxsMsgAuth, src)
+		}
+	} else if key != nil {
+		return nil, hdr, src, rem, fmt.Errorf("%w: frame not sealed", ErrPxsMsgAuth)
+	}
+	//payload only; bytes left after known fields are ignored,
+	//so newer versions may append fields.
+	rd := bytes.NewReader(frm[PxsMsgHeaderSize:bodyEnd])
+	//2. parse all type of msg
+	switch hdr.Typ {
+	case PxsMsgTypeHello:
+		hlo := new(PxsMsgHello)
+		hlo.Hdr = *hdr
+		//minVer,maxVer
+		flds := []interface{}{
+			&hlo.MinVer, &hlo.MaxVer,
+		}
+		if err = deserialize(flds, rd); err != nil {
+			goto WRONG_MSG_FORMAT
+		}
+		//cfgHash, which older senders omit
+		if rd.Len() >= 8 {
+			binary.Read(rd, binary.LittleEndian, &hlo.CfgHash)
+		}
+		msg = hlo
+	case PxsMsgTypeRequest:
+		req := new(PxsMsgRequest)
+		req.Hdr = *hdr
+		//size
+		if err = binary.Read(rd, binary.LittleEndian, &req.Val.Siz); err != nil {
+			goto WRONG_MSG_FORMAT
+		}
+		if req.Val.Siz == 0 { // should not be 0 length
+			goto WRONG_MSG_FORMAT
+		}
+		//octet
+		if err = readValueOct(rd, &req.Val); err != nil {
+			goto WRONG_MSG_FORMAT
+		}
+		msg = req
+	case PxsMsgTypePrepare:
+		pre := new(PxsMsgPrepare)
+		pre.Hdr = *hdr
+		//bal
+		if err = binary.Read(rd, binary.LittleEndian, &pre.Bal); err != nil {
+			goto WRONG_MSG_FORMAT
+		}
+		msg = pre
+	case PxsMsgTypePromise:
+		pro := new(PxsMsgPromise)
+		pro.Hdr = *hdr
+		//acc,bal,mVbal,mval.siz
+		flds := []interface{}{
+			&pro.Acc, &pro.Bal, &pro.MVBal,
+			&pro.MVal.Siz,
+		}
+		if err = deserialize(flds, rd); err != nil {
+			goto WRONG_MSG_FORMAT
+		}
+		//log.Println("Promise - acc,bal,mvbal,mval.siz:",
+		//	pro.acc, pro.bal, pro.mVbal, pro.mval.siz)
+		//mval.oct
+		if err = readValueOct(rd, &pro.MVal); err != nil {
+			goto WRONG_MSG_FORMAT
+		}
+		msg = pro
+	case PxsMsgTypeAccept:
+		acc := new(PxsMsgAccept)
+		acc.Hdr = *hdr
+		//bal,val.siz
+		flds := []interface{}{
+			&acc.Bal, &acc.Val.Siz,
+		}
+		if err = deserialize(flds, rd); err != nil {
+			goto WRONG_MSG_FORMAT
+		}
+		if err = readValueOct(rd, &acc.Val); err != nil {
+			goto WRONG_MSG_FORMAT
+		}
+		msg = acc
+	case PxsMsgTypeAccepted:
+		acd := new(PxsMsgAccepted)
+		acd.Hdr = *hdr
+		//acd.acc,acd.bal,acd.val.siz
+		flds := []interface{}{
+			&acd.Acc, &acd.Bal, &acd.Val.Siz,
+		}
+		if err = deserialize(flds, rd); err != nil {
+			goto WRONG_MSG_FORMAT
+		}
+		if err = readValueOct(rd, &acd.Val); err != nil {
+			goto WRONG_MSG_FORMAT
+		}
+		msg = acd
+	case PxsMsgTypeCommit:
+		cmt := new(PxsMsgCommit)
+		cmt.Hdr = *hdr
+		//bal
+		if err = binary.Read(rd, binary.LittleEndian, &cmt.Bal); err != nil {
+			goto WRONG_MSG_FORMAT
+		}
+		//val, if sent
+		if err = readOptValue(rd, &cmt.Val); err != nil {
+			goto WRONG_MSG_FORMAT
+		}
+		msg = cmt
+	case PxsMsgTypeFragment:
+		frg := new(PxsMsgFragment)
+		frg.Hdr = *hdr
+		//fid,off,tot,oct.siz
+		flds := []interface{}{
+			&frg.FID, &frg.Off, &frg.Tot, &frg.Oct.Siz,
+		}
+		if err = deserialize(flds, rd); err != nil {
+			goto WRONG_MSG_FORMAT
+		}
+		if err = readValueOct(rd, &frg.Oct); err != nil {
+			goto WRONG_MSG_FORMAT
+		}
+		msg = frg
+	case PxsMsgTypeResponse:
+		rsp := new(PxsMsgResponse)
+		rsp.Hdr = *hdr
+		//ret
+		if err = binary.Read(rd, binary.LittleEndian, &rsp.Ret); err != nil {
+			goto WRONG_MSG_FORMAT
+		}
+		//val, if sent
+		if err = readOptValue(rd, &rsp.Val); err != nil {
+			goto WRONG_MSG_FORMAT
+		}
+		msg = rsp
+	case PxsMsgTypeQuery:
+		qry := new(PxsMsgQuery)
+		qry.Hdr = *hdr
+		//seq
+		if err = binary.Read(rd, binary.LittleEndian, &qry.Seq); err != nil {
+			goto WRONG_MSG_FORMAT
+		}
+		msg = qry
+	case PxsMsgTypeLog:
+		lg := new(PxsMsgLog)
+		lg.Hdr = *hdr
+		//seq,ret,next,leader
+		flds := []interface{}{
+			&lg.Seq, &lg.Ret, &lg.Next, &lg.Leader,
+		}
+		if err = deserialize(flds, rd); err != nil {
+			goto WRONG_MSG_FORMAT
+		}
+		//val, if sent
+		if err = readOptValue(rd, &lg.Val); err != nil {
+			goto WRONG_MSG_FORMAT
+		}
+		msg = lg
+	default: //skipped, for forward compatibility.
+		return nil, hdr, src, rem, fmt.Errorf("%w: 0x%02x", ErrPxsMsgUnknownType, hdr.Typ)
+	}
+	return msg, hdr, src, rem, nil
+WRONG_MSG_FORMAT:
+	if err == nil {
+		return nil, hdr, src, rem, ErrPxsMsgMalformed
+	}
+	return nil, hdr, src, rem, fmt.Errorf("%w: %w", ErrPxsMsgMalformed, err)
+}
+
+//PxsMsgFrameLen : length of the frame whose header starts hdr, which
+//holds PxsMsgHeaderSize bytes at least; ErrPxsMsgMagic or
+//ErrPxsMsgTooLarge if it is no header, the stream is out of sync.
+func PxsMsgFrameLen(hdr []byte) (int, error) {
+	if mgc := binary.LittleEndian.Uint16(hdr); mgc != PxsMsgMagic {
+		return 0, fmt.Errorf("%w: 0x%04x", ErrPxsMsgMagic, mgc)
+	}
+	siz := binary.LittleEndian.Uint32(hdr[4:]) //hdr.siz
+	frmLen := PxsMsgHeaderSize + int(siz) + PxsMsgCRCSize
+	if hdr[3]&PxsMsgFlagAuth != 0 { //hdr.flg
+		frmLen += PxsMsgAuthSize
+	}
+	if frmLen > maxPxsMsgFrameSize() {
+		return 0, fmt.Errorf("%w: siz:%d", ErrPxsMsgTooLarge, siz)
+	}
+	return frmLen, nil
+}
+
+//maxPxsMsgFrameSize : largest frame DecodeOnePxsMsg waits for.
+func maxPxsMsgFrameSize() int {
+	return PxsMsgHeaderSize + pxsMsgMaxFixedSize + int(MaxValueSize) + PxsMsgAuthSize + PxsMsgCRCSize
+}
+
+//SealPxsMsg : frame frm sent by node src, authenticated with key: the
+//auth flag is set, src and an HMAC-SHA256 of the frame so far are put
+//before the CRC.
+func SealPxsMsg(frm []byte, src uint32, key []byte) ([]byte, error) {
+	if len(frm) < PxsMsgHeaderSize+PxsMsgCRCSize || frm[3]&PxsMsgFlagAuth != 0 {
+		return nil, fmt.Errorf("%w: not an unsealed frame", ErrPxsMsgMalformed)
+	}
+	out := make([]byte, 0, len(frm)+PxsMsgAuthSize)
+	out = append(out, frm[:len(frm)-PxsMsgCRCSize]...)
+	out[3] |= PxsMsgFlagAuth //hdr.flg
+	out = binary.LittleEndian.AppendUint32(out, src)
+	out = append(out, pxsMsgMAC(out, key)...)
+	return binary.LittleEndian.AppendUint32(out, crc32.Checksum(out, crcTable)), nil
+}
+
+//VerifyPxsMsg : sender named by frame frm, which must be sealed with key.
+func VerifyPxsMsg(frm []byte, key []byte) (uint32, error) {
+	if len(frm) < PxsMsgHeaderSize || binary.LittleEndian.Uint16(frm) != PxsMsgMagic {
+		return 0, fmt.Errorf("%w: not a frame", ErrPxsMsgMalformed)
+	}
+	bodyEnd := PxsMsgHeaderSize + int(binary.LittleEndian.Uint32(frm[4:])) //hdr.siz
+	sumAt := bodyEnd + PxsMsgAuthSize
+	if frm[3]&PxsMsgFlagAuth == 0 || len(frm) != sumAt+PxsMsgCRCSize {
+		return 0, fmt.Errorf("%w: frame not sealed", ErrPxsMsgAuth)
+	}
+	src := binary.LittleEndian.Uint32(frm[bodyEnd:])
+	if !hmac.Equal(frm[bodyEnd+4:sumAt], pxsMsgMAC(frm[:bodyEnd+4], key)) {
+		return src, fmt.Errorf("%w: bad mac, src:%d", ErrPxsMsgAuth, src)
+	}
+	return src, nil
+}
+
+//pxsMsgMAC : HMAC-SHA256 of bs with key.
+func pxsMsgMAC(bs, key []byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write(bs)
+	return mac.Sum(nil)
+}
+
+//readValueOct : read v.siz bytes of v.oct, never more than the payload holds.
+func readValueOct(rd *bytes.Reader, v *Value) error {
+	if v.Siz > MaxValueSize {
+		return fmt.Errorf("%w: %d > %d", ErrValueTooLarge, v.Siz, MaxValueSize)
+	}
+	if int64(v.Siz) > int64(rd.Len()) {
+		return fmt.Errorf("value siz:%d, only %d bytes left", v.Siz, rd.Len())
+	}
+	if v.Siz == 0 {
+		return nil
+	}
+	v.Oct = make([]byte, v.Siz)
+	_, err := io.ReadFull(rd, v.Oct)
+	return err
+}
+
+//readOptValue : read a trailing value, which older senders omit.
+func readOptValue(rd *bytes.Reader, v *Value) error {
+	if rd.Len() == 0 {
+		return nil
+	}
+	if err := binary.Read(rd, binary.LittleEndian, &v.Siz); err != nil {
+		return err
+	}
+	return readValueOct(rd, v)
+}
@@ -0,0 +1,569 @@
+package wire
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+	"log"
+	"testing"
+)
+
+func TestBytesBuffer(t *testing.T) {
+	var buf bytes.Buffer
+	var rd io.Reader
+	var num uint32
+	//feed buffer
+	oct := []byte{0xFF, 0xFF, 0, 0}
+	buf.Write(oct)
+	rd = &buf //buffer to reader
+	if err := binary.Read(rd, binary.LittleEndian, &num); err != nil {
+		t.Error("binary.Read fail")
+	}
+	log.Println("num:", num)
+	log.Println("buf:len,Bytes:", buf.Len(), buf.Bytes())
+	buf.Reset()
+}
+
+func TestWireformat(t *testing.T) {
+	//serialize/deserialize test for all PxsMsg.
+	var buffer bytes.Buffer
+	var rd io.Reader = &buffer
+	//0.Test value
+	{
+		var val Value
+		val.Siz = 4
+		oct := []byte{42, 84, 42, 84}
+		val.Oct = oct[0:val.Siz]
+		fld := []interface{}{&val.Siz, &val.Oct}
+		bs0, err := serialize(fld)
+		if err != nil {
+			t.Error("m1 encode:", err)
+		}
+		log.Println("val,bs0=", val, bs0)
+		//deser
+		var val2 Value
+		buffer.Write(bs0)
+		//siz
+		fld2 := []interface{}{&val2.Siz}
+		err = deserialize(fld2, rd)
+		if err != nil {
+			log.Println("deser - err:", err)
+		}
+		log.Printf("deser - val2:%+v\n", val2)
+		//oct
+		val2.Oct = make([]byte, val2.Siz)
+		fld2 = []interface{}{&val2.Oct}
+		err = deserialize(fld2, rd)
+		if err != nil {
+			log.Println("deser - err:", err)
+		}
+		log.Printf("deser - val2:%+v\n", val2)
+	}
+	{
+		//1.Request
+		var v = &Value{4, []byte{101, 0, 0, 0}}
+		var s = uint32(100)
+		m1 := NewPxsMsgRequest(s, v)
+		bs1, err := m1.Encode()
+		if bs1 == nil || err != nil {
+			t.Error("m1 encode:", err)
+		}
+		msg, hdr, rem, err := DecodeOnePxsMsg(&buffer, bs1)
+		m2, ok := msg.(*PxsMsgRequest)
+		if err != nil || !ok || rem != 0 || hdr == nil {
+			t.Error("m2 decode: err,ok,rem,bs1,msg=", err, ok, rem, bs1, msg)
+		}
+		bs2, _ := m2.Encode()
+		if !bytes.Equal(bs1, bs2) {
+			t.Error("m1, m2 mismatch, m1:", m1, "m2:", m2)
+		}
+		log.Printf("m1:%+v,bs1:%+v\n", m1, bs1)
+		log.Printf("m2:%+v,bs2:%+v\n", m1, bs2)
+	}
+	{
+		//2.Prepare - P1a msg
+		m1 := NewPxsMsgPrepare(101, 101)
+		bs1, _ := m1.Encode()
+		msg, _, rem, err := DecodeOnePxsMsg(&buffer, bs1)
+		m2, ok := msg.(*PxsMsgPrepare)
+		if err != nil || !ok || rem != 0 {
+			t.Error("m2 decode:", err, ok, rem)
+		}
+		bs2, _ := m2.Encode()
+		if !bytes.Equal(bs1, bs2) {
+			t.Error("m1, m2 mismatch, m1:", m1, "m2:", m2)
+		}
+		log.Printf("m1:%+v,bs1:%+v\n", m1, bs1)
+		log.Printf("m2:%+v,bs2:%+v\n", m1, bs2)
+	}
+	{
+		//3.Promise - P1b msg
+		v := new(Value)
+		v.Siz = 4
+		v.Oct = make([]byte, 4)
+		v.Oct[0] = 42
+		m1 := NewPxsMsgPromise(1, 1, 101, 100, v)
+		bs1, _ := m1.Encode()
+		log.Println("Promise - bs1:", bs1)
+		msg, _, rem, err := DecodeOnePxsMsg(&buffer, bs1)
+		m2, ok := msg.(*PxsMsgPromise)
+		if err != nil || !ok || rem != 0 {
+			t.Error("m2 decode:", err, ok, rem, bs1)
+		}
+		log.Println("Promise - m2:", m2) //XXX
+		bs2, _ := m2.Encode()
+		if !bytes.Equal(bs1, bs2) {
+			t.Error("m1, m2 mismatch, m1:", m1, "m2:", m2)
+		}
+		log.Printf("m1:%+v,bs1:%+v\n", m1, bs1)
+		log.Printf("m2:%+v,bs2:%+v\n", m1, bs2)
+	}
+	{
+		//4.Accept - P2a msg
+		v := new(Value)
+		v.Siz = 4
+		v.Oct = make([]byte, 4)
+		v.Oct = []byte{42, 42, 42, 42}
+		m1 := NewPxsMsgAccept(1, 101, v)
+		bs1, _ := m1.Encode()
+		log.Println("Accept - bs1:", bs1)
+		msg, _, rem, err := DecodeOnePxsMsg(&buffer, bs1)
+		m2, ok := msg.(*PxsMsgAccept)
+		if err != nil || !ok || rem != 0 {
+			t.Error("m2 decode:", err, ok, rem, bs1)
+		}
+		log.Println("Accept - m2:", m2)
+		bs2, _ := m2.Encode()
+		if !bytes.Equal(bs1, bs2) {
+			t.Error("m1, m2 mismatch, m1:", m1, "m2:", m2)
+		}
+		log.Printf("m1:%+v,bs1:%+v\n", m1, bs1)
+		log.Printf("m2:%+v,bs2:%+v\n", m1, bs2)
+	}
+	{
+		//5.Accepted - P2b msg
+		v := new(Value)
+		v.Siz = 4
+		v.Oct = make([]byte, 4)
+		v.Oct = []byte{42, 42, 42, 42}
+		m1 := NewPxsMsgAccepted(1, 1, 101, v)
+		bs1, _ := m1.Encode()
+		log.Println("Accepted - bs1:", bs1)
+		msg, _, rem, err := DecodeOnePxsMsg(&buffer, bs1)
+		m2, ok := msg.(*PxsMsgAccepted)
+		if err != nil || !ok || rem != 0 {
+			t.Error("m2 decode:", err, ok, rem, bs1)
+		}
+		log.Println("Accept - m2:", m2)
+		bs2, _ := m2.Encode()
+		if !bytes.Equal(bs1, bs2) {
+			t.Error("m1, m2 mismatch, m1:", m1, "m2:", m2)
+		}
+		log.Printf("m1:%+v,bs1:%+v\n", m1, bs1)
+		log.Printf("m2:%+v,bs2:%+v\n", m1, bs2)
+	}
+	{
+		//6.Commit - P3a msg
+		m1 := NewPxsMsgCommit(1, 101, &Value{3, []byte("abc")})
+		bs1, _ := m1.Encode()
+		log.Println("Accepted - bs1:", bs1)
+		msg, _, rem, err := DecodeOnePxsMsg(&buffer, bs1)
+		m2, ok := msg.(*PxsMsgCommit)
+		if err != nil || !ok || rem != 0 {
+			t.Error("m2 decode:", err, ok, rem, bs1)
+		}
+		log.Println("Commit - m2:", m2)
+		bs2, _ := m2.Encode()
+		if !bytes.Equal(bs1, bs2) {
+			t.Error("m1, m2 mismatch, m1:", m1, "m2:", m2)
+		}
+		log.Printf("m1:%+v,bs1:%+v\n", m1, bs1)
+		log.Printf("m2:%+v,bs2:%+v\n", m1, bs2)
+	}
+	{
+		//7.Response - P0b msg
+		m1 := NewPxsMsgResponse(1, 0, &Value{2, []byte("ok")})
+		bs1, _ := m1.Encode()
+		log.Println("Response - bs1:", bs1)
+		msg, _, rem, err := DecodeOnePxsMsg(&buffer, bs1)
+		m2, ok := msg.(*PxsMsgResponse)
+		if err != nil || !ok || rem != 0 {
+			t.Error("m2 decode:", err, ok, rem, bs1)
+		}
+		log.Println("Response - m2:", m2)
+		bs2, _ := m2.Encode()
+		if !bytes.Equal(bs1, bs2) {
+			t.Error("m1, m2 mismatch, m1:", m1, "m2:", m2)
+		}
+		log.Printf("m1:%+v,bs1:%+v\n", m1, bs1)
+		log.Printf("m2:%+v,bs2:%+v\n", m1, bs2)
+	}
+	{
+		//8.Query, Log - read the log
+		m1 := NewPxsMsgQuery(7, 3)
+		bs1, _ := m1.Encode()
+		msg, _, rem, err := DecodeOnePxsMsg(&buffer, bs1)
+		if m2, ok := msg.(*PxsMsgQuery); err != nil || !ok || rem != 0 || *m2 != *m1 {
+			t.Error("query decode:", err, ok, rem, msg)
+		}
+		l1 := NewPxsMsgLog(7, 3, 0, 9, 2, &Value{3, []byte("abc")})
+		bs1, _ = l1.Encode()
+		msg, _, rem, err = DecodeOnePxsMsg(&buffer, bs1)
+		l2, ok := msg.(*PxsMsgLog)
+		if err != nil || !ok || rem != 0 {
+			t.Fatal("log decode:", err, ok, rem, bs1)
+		}
+		bs2, _ := l2.Encode()
+		if !bytes.Equal(bs1, bs2) || l2.Next != 9 || l2.Leader != 2 {
+			t.Error("l1, l2 mismatch, l1:", l1, "l2:", l2)
+		}
+		//not chosen: no value
+		bs1, _ = NewPxsMsgLog(8, 4, 5, 9, 2, nil).Encode()
+		msg, _, _, err = DecodeOnePxsMsg(&buffer, bs1)
+		if l2, ok := msg.(*PxsMsgLog); err != nil || !ok || l2.Ret != 5 || !l2.Val.IsNone() {
+			t.Error("log without value:", err, msg)
+		}
+	}
+}
+
+//TestWireformatOptValue : commit and response from older peers, without a value.
+func TestWireformatOptValue(t *testing.T) {
+	var buffer bytes.Buffer
+	cmt, _ := encodeFrame([]interface{}{newPxsMsgHeader(PxsMsgTypeCommit, 1, 4), uint32(101)})
+	rsp, _ := encodeFrame([]interface{}{newPxsMsgHeader(PxsMsgTypeResponse, 1, 4), uint32(0)})
+	msg, _, _, err := DecodeOnePxsMsg(&buffer, cmt)
+	if m, ok := msg.(*PxsMsgCommit); !ok || err != nil || m.Bal != 101 || !m.Val.IsNone() {
+		t.Error("old commit:", msg, err)
+	}
+	msg, _, _, err = DecodeOnePxsMsg(&buffer, rsp)
+	if m, ok := msg.(*PxsMsgResponse); !ok || err != nil || !m.Val.IsNone() {
+		t.Error("old response:", msg, err)
+	}
+	//a value cut short is still malformed
+	bad, _ := encodeFrame([]interface{}{newPxsMsgHeader(PxsMsgTypeCommit, 1, 10), uint32(101), uint32(5), uint16(1)})
+	if _, _, _, err = DecodeOnePxsMsg(&buffer, bad); !errors.Is(err, ErrPxsMsgMalformed) {
+		t.Error("short value:", err)
+	}
+}
+
+func TestWireformatMagicVersion(t *testing.T) {
+	var buffer bytes.Buffer
+	//0. garbage from other app
+	_, _, _, err := DecodeOnePxsMsg(&buffer, []byte("xxx,foo,bar,baz,qux"))
+	if !errors.Is(err, ErrPxsMsgMagic) {
+		t.Error("garbage decode: err =", err)
+	}
+	buffer.Reset()
+	//1. unknown version
+	bs, _ := NewPxsMsgPrepare(1, 101).Encode()
+	bs[2] = PxsProtoVersion + 1
+	reseal(bs)
+	_, _, _, err = DecodeOnePxsMsg(&buffer, bs)
+	if !errors.Is(err, ErrPxsMsgVersion) {
+		t.Error("future version decode: err =", err)
+	}
+	log.Println("future version:", err)
+	buffer.Reset()
+	//2. hello is decoded whatever its version
+	m1 := NewPxsMsgHello(0x0123456789abcdef)
+	bs1, _ := m1.Encode()
+	bs1[2] = PxsProtoVersion + 1
+	reseal(bs1)
+	msg, _, rem, err := DecodeOnePxsMsg(&buffer, bs1)
+	m2, ok := msg.(*PxsMsgHello)
+	if err != nil || !ok || rem != 0 {
+		t.Fatal("hello decode:", err, ok, rem, bs1)
+	}
+	if m2.MinVer != uint32(PxsProtoVersionMin) || m2.MaxVer != uint32(PxsProtoVersion) ||
+		m2.CfgHash != m1.CfgHash {
+		t.Error("hello mismatch, m2:", m2)
+	}
+	//3. hello of an older sender, without cfgHash
+	bs1, _ = encodeFrame([]interface{}{newPxsMsgHeader(PxsMsgTypeHello, 0, 8), uint32(1), uint32(1)})
+	msg, _, _, err = DecodeOnePxsMsg(&buffer, bs1)
+	if m2, ok := msg.(*PxsMsgHello); err != nil || !ok || m2.MaxVer != 1 || m2.CfgHash != 0 {
+		t.Error("old hello:", msg, err)
+	}
+}
+
+//reseal : fix CRC of a hand-patched frame.
+func reseal(bs []byte) {
+	n := len(bs) - PxsMsgCRCSize
+	binary.LittleEndian.PutUint32(bs[n:], crc32.Checksum(bs[:n], crcTable))
+}
+
+func TestPxsMsgHelloCheckVersion(t *testing.T) {
+	cur := uint32(PxsProtoVersion)
+	cases := []struct {
+		min, max uint32
+		ver      uint8
+		ok       bool
+	}{
+		{cur, cur, PxsProtoVersion, true},
+		{1, cur + 2, PxsProtoVersion, true},
+		{cur + 1, cur + 2, PxsProtoVersion, false},
+		{0, cur - 1, PxsProtoVersion, false},
+		{cur, cur, PxsProtoVersion + 1, false},
+	}
+	for _, c := range cases {
+		hlo := NewPxsMsgHello(0)
+		hlo.MinVer, hlo.MaxVer, hlo.Hdr.Ver = c.min, c.max, c.ver
+		if err := hlo.CheckVersion(); (err == nil) != c.ok {
+			t.Errorf("CheckVersion%+v = %v\n", c, err)
+		}
+	}
+}
+
+func TestWireformatChecksum(t *testing.T) {
+	var buffer bytes.Buffer
+	v := &Value{4, []byte{42, 42, 42, 42}}
+	bs, _ := NewPxsMsgAccept(1, 101, v).Encode()
+	if len(bs) != PxsMsgHeaderSize+4+4+4+PxsMsgCRCSize {
+		t.Error("unexpected frame size:", len(bs))
+	}
+	//flip one bit of the value
+	bad := append([]byte(nil), bs...)
+	bad[len(bad)-PxsMsgCRCSize-1] ^= 0x01
+	msg, _, _, err := DecodeOnePxsMsg(&buffer, bad)
+	if msg != nil || !errors.Is(err, ErrPxsMsgChecksum) {
+		t.Error("corrupted value accepted: msg,err =", msg, err)
+	}
+	buffer.Reset()
+	//intact frame still decodes
+	msg, _, _, err = DecodeOnePxsMsg(&buffer, bs)
+	if _, ok := msg.(*PxsMsgAccept); !ok || err != nil {
+		t.Error("intact decode: msg,err =", msg, err)
+	}
+}
+
+func TestWireformatFraming(t *testing.T) {
+	var buffer bytes.Buffer
+	v := &Value{4, []byte{1, 2, 3, 4}}
+	bs1, _ := NewPxsMsgAccept(7, 101, v).Encode()
+	bs2, _ := NewPxsMsgCommit(7, 101, v).Encode()
+	//unknown but well-formed type from a newer peer
+	unk, _ := encodeFrame([]interface{}{
+		newPxsMsgHeader(PxsMsgType(0x7f), 7, 8), uint32(1), uint32(2),
+	})
+	stream := append(append(append([]byte(nil), bs1...), unk...), bs2...)
+
+	//1. byte by byte: partial frames wait for more bytes.
+	var got []PxsMsgType
+	for i := range stream {
+		for bs := stream[i : i+1]; ; bs = nil {
+			msg, hdr, _, err := DecodeOnePxsMsg(&buffer, bs)
+			if errors.Is(err, ErrPxsMsgIncomplete) {
+				break
+			}
+			if errors.Is(err, ErrPxsMsgUnknownType) {
+				got = append(got, hdr.Typ)
+				continue
+			}
+			if err != nil || msg == nil {
+				t.Fatal("decode at", i, "err:", err)
+			}
+			got = append(got, hdr.Typ)
+		}
+	}
+	want := []PxsMsgType{PxsMsgTypeAccept, 0x7f, PxsMsgTypeCommit}
+	if len(got) != len(want) || got[0] != want[0] || got[1] != want[1] || got[2] != want[2] {
+		t.Error("byte by byte: got", got, "want", want)
+	}
+	if buffer.Len() != 0 {
+		t.Error("bytes left:", buffer.Len())
+	}
+
+	//2. all frames in one buffer: decoded one by one.
+	msg, _, rem, err := DecodeOnePxsMsg(&buffer, stream)
+	if _, ok := msg.(*PxsMsgAccept); !ok || err != nil || rem != len(unk)+len(bs2) {
+		t.Error("1st frame: msg,rem,err =", msg, rem, err)
+	}
+	_, _, rem, err = DecodeOnePxsMsg(&buffer, nil)
+	if !errors.Is(err, ErrPxsMsgUnknownType) || rem != len(bs2) {
+		t.Error("2nd frame: rem,err =", rem, err)
+	}
+	msg, _, rem, err = DecodeOnePxsMsg(&buffer, nil)
+	if _, ok := msg.(*PxsMsgCommit); !ok || err != nil || rem != 0 {
+		t.Error("3rd frame: msg,rem,err =", msg, rem, err)
+	}
+	_, _, _, err = DecodeOnePxsMsg(&buffer, nil)
+	if !errors.Is(err, ErrPxsMsgIncomplete) {
+		t.Error("empty buffer: err =", err)
+	}
+
+	//3. payload shorter than its own value length
+	bad, _ := encodeFrame([]interface{}{
+		newPxsMsgHeader(PxsMsgTypeAccept, 7, 8), uint32(101), uint32(4),
+	})
+	_, _, _, err = DecodeOnePxsMsg(&buffer, bad)
+	if !errors.Is(err, ErrPxsMsgMalformed) || buffer.Len() != 0 {
+		t.Error("short payload: err =", err, "left:", buffer.Len())
+	}
+}
+
+func TestWireformatValueSize(t *testing.T) {
+	var buffer bytes.Buffer
+	//1. encode refuses oversized or inconsistent values
+	big := &Value{MaxValueSize + 1, make([]byte, MaxValueSize+1)}
+	if _, err := NewPxsMsgRequest(1, big).Encode(); !errors.Is(err, ErrValueTooLarge) {
+		t.Error("encode big value: err =", err)
+	}
+	if _, err := NewPxsMsgAccept(1, 101, &Value{8, []byte{1}}).Encode(); !errors.Is(err, ErrPxsMsgMalformed) {
+		t.Error("encode bad value siz: err =", err)
+	}
+	//2. value length of 4GiB in a small frame: no allocation, frame dropped
+	bad, _ := encodeFrame([]interface{}{
+		newPxsMsgHeader(PxsMsgTypeAccept, 7, 12), uint32(101), uint32(0xFFFFFFFF), uint32(0),
+	})
+	_, _, _, err := DecodeOnePxsMsg(&buffer, bad)
+	if !errors.Is(err, ErrValueTooLarge) || !errors.Is(err, ErrPxsMsgMalformed) || buffer.Len() != 0 {
+		t.Error("4GiB value siz: err =", err, "left:", buffer.Len())
+	}
+	//3. frame header claiming 4GiB: rejected before buffering it
+	hdr := newPxsMsgHeader(PxsMsgTypeAccept, 7, 0xFFFFFFF0)
+	bs, _ := serialize([]interface{}{hdr})
+	_, _, _, err = DecodeOnePxsMsg(&buffer, bs)
+	if !errors.Is(err, ErrPxsMsgTooLarge) {
+		t.Error("4GiB frame: err =", err)
+	}
+	if _, err = PxsMsgFrameLen(bs); !errors.Is(err, ErrPxsMsgTooLarge) {
+		t.Error("4GiB frame len: err =", err)
+	}
+	buffer.Reset()
+	//4. largest value still passes
+	max := &Value{MaxValueSize, make([]byte, MaxValueSize)}
+	bs, _ = NewPxsMsgAccept(1, 101, max).Encode()
+	if msg, _, _, err := DecodeOnePxsMsg(&buffer, bs); msg == nil || err != nil {
+		t.Error("max value: err =", err)
+	}
+	if n, err := PxsMsgFrameLen(bs); n != len(bs) || err != nil {
+		t.Error("max value frame len:", n, len(bs), err)
+	}
+}
+
+func TestWireformatFragment(t *testing.T) {
+	v := &Value{300 * 1024, make([]byte, 300*1024)}
+	for i := range v.Oct {
+		v.Oct[i] = byte(i)
+	}
+	frm, err := NewPxsMsgAccept(3, 101, v).Encode()
+	if err != nil {
+		t.Fatal("encode:", err)
+	}
+	frgs, err := FragmentPxsMsg(1, frm, 0)
+	if err != nil || len(frgs) != len(frm)/pxsFragmentChunk+1 {
+		t.Fatal("fragment: n,err =", len(frgs), err)
+	}
+	var buffer bytes.Buffer
+	decode := func(bs []byte) *PxsMsgFragment {
+		if len(bs) > MaxDatagramSize {
+			t.Error("fragment larger than a datagram:", len(bs))
+		}
+		msg, _, _, err := DecodeOnePxsMsg(&buffer, bs)
+		frg, ok := msg.(*PxsMsgFragment)
+		if !ok || err != nil {
+			t.Fatal("decode fragment:", err)
+		}
+		return frg
+	}
+	//1. out of order, with a duplicate: whole only with the last piece
+	var asm PxsMsgAssembly
+	order := []int{3, 0, 2, 2, 4, 1}
+	var whole []byte
+	for i, k := range order {
+		whole, err = asm.Add(decode(frgs[k]))
+		if err != nil || (whole != nil) != (i == len(order)-1) {
+			t.Fatal("add piece", k, "whole:", whole != nil, "err:", err)
+		}
+	}
+	if !bytes.Equal(whole, frm) {
+		t.Fatal("reassembled msg mismatch")
+	}
+	//2. a piece of another msg drops the partial one
+	old, _ := FragmentPxsMsg(0, frm, 0)
+	asm.Add(decode(frgs[0]))
+	asm.Add(decode(old[1]))
+	for _, bs := range frgs[1:] {
+		if whole, _ = asm.Add(decode(bs)); whole != nil {
+			t.Fatal("msg complete without its 1st piece")
+		}
+	}
+	//3. piece beyond the msg end
+	bad := NewPxsMsgFragment(3, 2, uint32(len(frm)-1), uint32(len(frm)), []byte{1, 2})
+	if _, err = asm.Add(bad); !errors.Is(err, ErrPxsMsgMalformed) {
+		t.Error("piece beyond end: err =", err)
+	}
+	//4. overlapping pieces: not counted twice
+	tot := uint32(len(frm))
+	asm.Add(NewPxsMsgFragment(3, 4, 0, tot, frm[:tot/2]))
+	if _, err = asm.Add(NewPxsMsgFragment(3, 4, 1, tot, frm[1:tot/2+1])); !errors.Is(err, ErrPxsMsgMalformed) {
+		t.Error("overlapping piece: err =", err)
+	}
+	if whole, err = asm.Add(NewPxsMsgFragment(3, 4, tot/2, tot, frm[tot/2:tot-1])); whole != nil || err != nil {
+		t.Fatal("msg complete with a byte missing:", err)
+	}
+	if whole, err = asm.Add(NewPxsMsgFragment(3, 4, tot-1, tot, frm[tot-1:])); !bytes.Equal(whole, frm) || err != nil {
+		t.Fatal("msg not reassembled after an overlap:", err)
+	}
+}
+
+func TestWireformatSeal(t *testing.T) {
+	var buffer bytes.Buffer
+	key := []byte("s3cret")
+	v := &Value{3, []byte{1, 2, 3}}
+	frm, _ := NewPxsMsgAccept(5, 101, v).Encode()
+	bs, err := SealPxsMsg(frm, 7, key)
+	if err != nil || len(bs) != len(frm)+PxsMsgAuthSize {
+		t.Fatal("SealPxsMsg: len,err =", len(bs), err)
+	}
+	if _, err = SealPxsMsg(bs, 7, key); err == nil {
+		t.Error("sealed twice")
+	}
+	//1. right key
+	msg, _, src, _, err := DecodeOnePxsMsgAuth(&buffer, bs, key)
+	if m, ok := msg.(*PxsMsgAccept); !ok || err != nil || src != 7 || !bytes.Equal(m.Val.Oct, v.Oct) {
+		t.Fatal("decode sealed: msg,src,err =", msg, src, err)
+	}
+	//2. no key: MAC not checked
+	msg, _, _, err = DecodeOnePxsMsg(&buffer, bs)
+	if _, ok := msg.(*PxsMsgAccept); !ok || err != nil {
+		t.Error("decode sealed without key: msg,err =", msg, err)
+	}
+	//3. wrong key
+	msg, _, _, _, err = DecodeOnePxsMsgAuth(&buffer, bs, []byte("guess"))
+	if msg != nil || !errors.Is(err, ErrPxsMsgAuth) {
+		t.Error("wrong key: msg,err =", msg, err)
+	}
+	//4. unsealed frame
+	msg, _, _, _, err = DecodeOnePxsMsgAuth(&buffer, frm, key)
+	if msg != nil || !errors.Is(err, ErrPxsMsgAuth) {
+		t.Error("unsealed: msg,err =", msg, err)
+	}
+	//5. sender or value changed, CRC made good again
+	for _, at := range []int{len(frm) - PxsMsgCRCSize, len(frm) - PxsMsgCRCSize - 1} {
+		bad := append([]byte(nil), bs...)
+		bad[at] ^= 0x01
+		reseal(bad)
+		msg, _, _, _, err = DecodeOnePxsMsgAuth(&buffer, bad, key)
+		if msg != nil || !errors.Is(err, ErrPxsMsgAuth) {
+			t.Error("tampered at", at, ": msg,err =", msg, err)
+		}
+	}
+	if buffer.Len() != 0 {
+		t.Error("bytes left in buffer:", buffer.Len())
+	}
+	//6. frame checked alone
+	if src, err := VerifyPxsMsg(bs, key); src != 7 || err != nil {
+		t.Error("VerifyPxsMsg: src,err =", src, err)
+	}
+	for _, bad := range [][]byte{frm, bs[:len(bs)-1], bs[:10]} {
+		if _, err := VerifyPxsMsg(bad, key); err == nil {
+			t.Error("VerifyPxsMsg passed", len(bad), "bytes")
+		}
+	}
+	if _, err := VerifyPxsMsg(bs, []byte("guess")); !errors.Is(err, ErrPxsMsgAuth) {
+		t.Error("VerifyPxsMsg wrong key: err =", err)
+	}
+}